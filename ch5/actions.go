@@ -1,12 +1,13 @@
 package main
 
 import (
-	"compress/gzip"
+	"bufio"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 func filterOut(path, ext string, minSize int64, info os.FileInfo) bool {
@@ -33,13 +34,73 @@ func delFile(path string, delLogger *log.Logger) error {
 	return nil
 }
 
-func archiveFile(destDir, root, path string) error {
-	info, err := os.Stat(destDir)
-	if err != nil {
+func trashFile(root, path string, trash *trashBatch, delLogger *log.Logger) error {
+	if err := trash.add(root, path); err != nil {
 		return err
 	}
+	delLogger.Println(path)
+	return nil
+}
 
-	relDir, err := filepath.Rel(root, filepath.Dir(path))
+// remover deletes matched files, or moves them to the trash batch when
+// there is one. Files archived into a bundle are only complete once the
+// bundle is closed, so their deletes can be deferred: they are queued and
+// run by flush.
+type remover struct {
+	root      string
+	trash     *trashBatch
+	delLogger *log.Logger
+	rec       *recorder
+	deferred  bool
+	queue     []queuedFile
+}
+
+type queuedFile struct {
+	path string
+	info os.FileInfo
+}
+
+func (r *remover) remove(path string, info os.FileInfo) error {
+	if r.deferred {
+		r.queue = append(r.queue, queuedFile{path: path, info: info})
+		return nil
+	}
+
+	return r.removeNow(path, info)
+}
+
+func (r *remover) removeNow(path string, info os.FileInfo) error {
+	if r.trash != nil {
+		if err := trashFile(r.root, path, r.trash, r.delLogger); err != nil {
+			return r.rec.fail(path, info, err)
+		}
+
+		target, _ := r.trash.target(r.root, path)
+		return r.rec.record(event{Action: "deleted", Path: path, Size: info.Size(), Source: path, Target: target})
+	}
+
+	if err := delFile(path, r.delLogger); err != nil {
+		return r.rec.fail(path, info, err)
+	}
+	return r.rec.record(event{Action: "deleted", Path: path, Size: info.Size()})
+}
+
+// flush runs the queued deletes.
+func (r *remover) flush() error {
+	queue := r.queue
+	r.queue = nil
+
+	for _, f := range queue {
+		if err := r.removeNow(f.path, f.info); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func archiveFile(destDir, root, path string, comp compression) error {
+	info, err := os.Stat(destDir)
 	if err != nil {
 		return err
 	}
@@ -48,27 +109,36 @@ func archiveFile(destDir, root, path string) error {
 		return fmt.Errorf("%s is not a directory", destDir)
 	}
 
-	dest := fmt.Sprintf("%s.gz", filepath.Base(path))
-	targetPath := filepath.Join(destDir, relDir, dest)
+	targetPath, err := archivePath(destDir, root, path, comp.algo)
+	if err != nil {
+		return err
+	}
 
 	if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
 		return err
 	}
 
-	out, err := os.OpenFile(targetPath, os.O_RDWR|os.O_CREATE, 0644)
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	inInfo, err := in.Stat()
 	if err != nil {
 		return err
 	}
-	defer out.Close()
 
-	in, err := os.Open(path)
+	out, err := os.OpenFile(targetPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
 		return err
 	}
-	defer in.Close()
+	defer out.Close()
 
-	zw := gzip.NewWriter(out)
-	zw.Name = filepath.Base(path)
+	zw, err := comp.newWriter(out, filepath.Base(path), inInfo.ModTime())
+	if err != nil {
+		return err
+	}
 
 	if _, err := io.Copy(zw, in); err != nil {
 		return err
@@ -80,3 +150,104 @@ func archiveFile(destDir, root, path string) error {
 
 	return out.Close()
 }
+
+func archivePath(destDir, root, path, ext string) (string, error) {
+	relDir, err := filepath.Rel(root, filepath.Dir(path))
+	if err != nil {
+		return "", err
+	}
+
+	dest := fmt.Sprintf("%s.%s", filepath.Base(path), ext)
+	return filepath.Join(destDir, relDir, dest), nil
+}
+
+func confirm(prompt string, in *bufio.Reader, out io.Writer) (bool, error) {
+	if _, err := fmt.Fprintf(out, "%s [y/N]: ", prompt); err != nil {
+		return false, err
+	}
+
+	ans, err := in.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+
+	ans = strings.ToLower(strings.TrimSpace(ans))
+	return ans == "y" || ans == "yes", nil
+}
+
+// confirmFile asks whether path should be processed. In "dir" mode the
+// answer is asked once per directory and reused for every file in it.
+func confirmFile(path string, cfg config, in *bufio.Reader, out io.Writer, dirAnswers map[string]bool) (bool, error) {
+	if in == nil {
+		return false, fmt.Errorf("confirm mode requires an input")
+	}
+
+	action := "Delete"
+	if cfg.archive != "" {
+		action = "Archive and delete"
+	}
+
+	if cfg.confirm == "file" {
+		return confirm(fmt.Sprintf("%s %s?", action, path), in, out)
+	}
+
+	dir := filepath.Dir(path)
+	if ok, found := dirAnswers[dir]; found {
+		return ok, nil
+	}
+
+	ok, err := confirm(fmt.Sprintf("%s matching files in %s?", action, dir), in, out)
+	if err != nil {
+		return false, err
+	}
+	dirAnswers[dir] = ok
+
+	return ok, nil
+}
+
+type dryRunTotals struct {
+	archived      int
+	archivedBytes int64
+	deleted       int
+	deletedBytes  int64
+}
+
+func dryRunFile(root, path string, info os.FileInfo, out io.Writer, cfg config, arc archiver, tot *dryRunTotals) error {
+	if arc == nil && !cfg.del {
+		return listFile(path, out)
+	}
+
+	if arc != nil {
+		target, err := arc.target(root, path)
+		if err != nil {
+			return err
+		}
+
+		if _, err := fmt.Fprintf(out, "would archive: %s -> %s (%d bytes)\n", path, target, info.Size()); err != nil {
+			return err
+		}
+		tot.archived++
+		tot.archivedBytes += info.Size()
+	}
+
+	if cfg.del {
+		action := "delete"
+		if cfg.trash != "" {
+			action = "move to trash"
+		}
+
+		if _, err := fmt.Fprintf(out, "would %s: %s (%d bytes)\n", action, path, info.Size()); err != nil {
+			return err
+		}
+		tot.deleted++
+		tot.deletedBytes += info.Size()
+	}
+
+	return nil
+}
+
+func (t dryRunTotals) print(out io.Writer) error {
+	_, err := fmt.Fprintf(out, "dry run: %d files (%d bytes) would be archived, %d files (%d bytes) would be deleted\n",
+		t.archived, t.archivedBytes, t.deleted, t.deletedBytes)
+	return err
+}
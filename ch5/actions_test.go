@@ -1,7 +1,11 @@
 package main
 
 import (
+	"fmt"
+	"io"
+	"log"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -35,3 +39,79 @@ func TestFilterOut(t *testing.T) {
 		})
 	}
 }
+
+func TestHumanBytes(t *testing.T) {
+	tests := []struct {
+		bytes    int64
+		expected string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 * 1024 * 1024, "5.0 MiB"},
+		{3 * 1024 * 1024 * 1024, "3.0 GiB"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			if res := humanBytes(tt.bytes); res != tt.expected {
+				t.Errorf("Expected %q, got %q instead\n", tt.expected, res)
+			}
+		})
+	}
+}
+
+func TestAddTop(t *testing.T) {
+	var list []duEntry
+	for i, size := range []int64{5, 1, 9, 3, 7, 9} {
+		list = addTop(list, duEntry{Path: fmt.Sprintf("f%d", i), Bytes: size}, 3)
+	}
+
+	expected := []duEntry{{Path: "f2", Bytes: 9}, {Path: "f5", Bytes: 9}, {Path: "f4", Bytes: 7}}
+	if len(list) != len(expected) {
+		t.Fatalf("Expected %d entries, got %d instead\n", len(expected), len(list))
+	}
+
+	for i, e := range expected {
+		if list[i] != e {
+			t.Errorf("Expected %v at %d, got %v instead\n", e, i, list[i])
+		}
+	}
+}
+
+func TestRemoverDeferred(t *testing.T) {
+	tempDir, cleanup := createTempDir(t, map[string]int{".log": 2})
+	defer cleanup()
+
+	rm := &remover{root: tempDir, delLogger: log.New(io.Discard, "", 0), rec: newRecorder(nil), deferred: true}
+
+	for _, name := range []string{"file1.log", "file2.log"} {
+		path := filepath.Join(tempDir, name)
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := rm.remove(path, info); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected %s to be kept until flush, got %q instead\n", name, err)
+		}
+	}
+
+	if err := rm.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 0 || rm.rec.files["deleted"] != 2 {
+		t.Errorf("Expected 2 files deleted, got %d left and %d deleted instead\n", len(files), rm.rec.files["deleted"])
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// archiver stores matched files in the archive directory, either one
+// compressed file per source file or all together in a single bundle.
+type archiver interface {
+	target(root, path string) (string, error)
+	add(root, path string) error
+	Close() error
+}
+
+func newArchiver(destDir, format string, level int) (archiver, error) {
+	maxLevel := 9
+	if strings.HasSuffix(format, "zst") {
+		maxLevel = 22
+	}
+
+	if level < 0 || level > maxLevel {
+		return nil, fmt.Errorf("invalid compression level %d for %q: use 0-%d", level, format, maxLevel)
+	}
+
+	switch format {
+	case "", "gz":
+		return fileArchiver{destDir: destDir, comp: compression{algo: "gz", level: level}}, nil
+	case "zst":
+		return fileArchiver{destDir: destDir, comp: compression{algo: "zst", level: level}}, nil
+	case "tar.gz", "tar.zst", "zip":
+		algo := strings.TrimPrefix(format, "tar.")
+		return &bundleArchiver{
+			bundle: filepath.Join(destDir, bundleName(format, time.Now())),
+			comp:   compression{algo: algo, level: level},
+		}, nil
+	}
+
+	return nil, fmt.Errorf("invalid archive format %q: use gz, zst, tar.gz, tar.zst or zip", format)
+}
+
+// bundleFormats are the formats that store all files in one bundle.
+var bundleFormats = []string{"tar.gz", "tar.zst", "zip"}
+
+// bundleTime is the layout of the time in bundle names.
+const bundleTime = "20060102-150405"
+
+// bundleName returns the name of a bundle created at t.
+func bundleName(format string, t time.Time) string {
+	return fmt.Sprintf("archive-%s.%s", t.Format(bundleTime), format)
+}
+
+// compression selects a compression algorithm and level. A level of 0
+// uses the algorithm's default.
+type compression struct {
+	algo  string
+	level int
+}
+
+// newWriter returns a compressing writer. The gzip header records name
+// and modTime so the file can be restored as it was.
+func (c compression) newWriter(w io.Writer, name string, modTime time.Time) (io.WriteCloser, error) {
+	switch c.algo {
+	case "gz":
+		level := c.level
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+
+		zw, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, err
+		}
+		zw.Name = name
+		zw.ModTime = modTime
+		return zw, nil
+	case "zst":
+		opts := []zstd.EOption{}
+		if c.level != 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(c.level)))
+		}
+		return zstd.NewWriter(w, opts...)
+	}
+
+	return nil, fmt.Errorf("invalid compression %q", c.algo)
+}
+
+type fileArchiver struct {
+	destDir string
+	comp    compression
+}
+
+func (a fileArchiver) target(root, path string) (string, error) {
+	return archivePath(a.destDir, root, path, a.comp.algo)
+}
+
+func (a fileArchiver) add(root, path string) error {
+	return archiveFile(a.destDir, root, path, a.comp)
+}
+
+func (a fileArchiver) Close() error {
+	return nil
+}
+
+// bundleArchiver writes every file into a single tar or zip bundle,
+// keeping paths relative to the root. The bundle is created on the first
+// call to add so that dry runs and empty runs leave nothing behind.
+type bundleArchiver struct {
+	bundle string
+	comp   compression
+
+	f  *os.File
+	cw io.WriteCloser
+	tw *tar.Writer
+	zw *zip.Writer
+}
+
+func (a *bundleArchiver) target(root, path string) (string, error) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s:%s", a.bundle, filepath.ToSlash(rel)), nil
+}
+
+func (a *bundleArchiver) open() error {
+	info, err := os.Stat(filepath.Dir(a.bundle))
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", filepath.Dir(a.bundle))
+	}
+
+	a.f, err = os.OpenFile(a.bundle, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasSuffix(a.bundle, ".zip") {
+		a.zw = zip.NewWriter(a.f)
+		if a.comp.level != 0 {
+			level := a.comp.level
+			a.zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+				return flate.NewWriter(w, level)
+			})
+		}
+		return nil
+	}
+
+	a.cw, err = a.comp.newWriter(a.f, "", time.Time{})
+	if err != nil {
+		return err
+	}
+	a.tw = tar.NewWriter(a.cw)
+
+	return nil
+}
+
+func (a *bundleArchiver) add(root, path string) error {
+	if a.f == nil {
+		if err := a.open(); err != nil {
+			return err
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return err
+	}
+	name := filepath.ToSlash(rel)
+
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if a.zw != nil {
+		hdr, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		hdr.Method = zip.Deflate
+
+		w, err := a.zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(w, in)
+		return err
+	}
+
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+
+	if err := a.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(a.tw, in)
+	return err
+}
+
+// Close completes the bundle and syncs it to disk, so the archived files
+// can safely be deleted once it returns.
+func (a *bundleArchiver) Close() error {
+	if a.f == nil {
+		return nil
+	}
+
+	if err := a.finish(); err != nil {
+		a.f.Close()
+		return err
+	}
+
+	if err := a.f.Sync(); err != nil {
+		a.f.Close()
+		return err
+	}
+
+	if err := a.f.Close(); err != nil {
+		return err
+	}
+
+	return syncDir(filepath.Dir(a.bundle))
+}
+
+// finish writes the end of the tar stream and flushes the compressor, or
+// writes the zip central directory.
+func (a *bundleArchiver) finish() error {
+	if a.zw != nil {
+		return a.zw.Close()
+	}
+
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+
+	return a.cw.Close()
+}
+
+// bundled reports whether arc writes a single bundle, which is only
+// readable once it is closed.
+func bundled(arc archiver) bool {
+	_, ok := arc.(*bundleArchiver)
+	return ok
+}
+
+// syncDir syncs the directory entries of dir to disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+type duEntry struct {
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+	Files int    `json:"files,omitempty"`
+}
+
+type duReport struct {
+	Dirs  []duEntry `json:"dirs"`
+	Files []duEntry `json:"files"`
+}
+
+// runDu adds up the sizes of matched files per directory. Directories
+// deeper than cfg.depth below root are counted in their ancestor at that
+// depth. Only the cfg.top largest directories and files are reported.
+func runDu(root string, out io.Writer, cfg config,
+	visit func(string, os.FileInfo, error) (bool, error)) error {
+
+	format := cfg.duFormat
+	if format == "" {
+		format = "table"
+	}
+
+	if format != "table" && format != "json" {
+		return fmt.Errorf("invalid du format %q: use table or json", format)
+	}
+
+	top := cfg.top
+	if top <= 0 {
+		top = 10
+	}
+
+	dirs := make(map[string]*duEntry)
+	var files []duEntry
+
+	err := filepath.Walk(root,
+		func(path string, info os.FileInfo, err error) error {
+			if ok, err := visit(path, info, err); !ok {
+				return err
+			}
+
+			rel, err := filepath.Rel(root, filepath.Dir(path))
+			if err != nil {
+				return err
+			}
+
+			var parts []string
+			if rel != "." {
+				parts = strings.Split(rel, string(filepath.Separator))
+			}
+
+			for i := 0; i <= len(parts) && i <= cfg.depth; i++ {
+				dir := filepath.Join(append([]string{root}, parts[:i]...)...)
+
+				d, ok := dirs[dir]
+				if !ok {
+					d = &duEntry{Path: dir}
+					dirs[dir] = d
+				}
+				d.Bytes += info.Size()
+				d.Files++
+			}
+
+			files = addTop(files, duEntry{Path: path, Bytes: info.Size()}, top)
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+
+	var r duReport
+	for _, d := range dirs {
+		r.Dirs = append(r.Dirs, *d)
+	}
+	sortEntries(r.Dirs)
+	if len(r.Dirs) > top {
+		r.Dirs = r.Dirs[:top]
+	}
+	r.Files = files
+
+	if format == "json" {
+		return json.NewEncoder(out).Encode(r)
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DIRECTORY\tSIZE\tFILES")
+	for _, d := range r.Dirs {
+		fmt.Fprintf(tw, "%s\t%s\t%d\n", d.Path, humanBytes(d.Bytes), d.Files)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(out)
+
+	tw = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tSIZE")
+	for _, f := range r.Files {
+		fmt.Fprintf(tw, "%s\t%s\n", f.Path, humanBytes(f.Bytes))
+	}
+
+	return tw.Flush()
+}
+
+func sortEntries(e []duEntry) {
+	sort.Slice(e, func(i, j int) bool {
+		if e[i].Bytes != e[j].Bytes {
+			return e[i].Bytes > e[j].Bytes
+		}
+		return e[i].Path < e[j].Path
+	})
+}
+
+// addTop inserts e into the largest-first list keeping at most n entries.
+func addTop(list []duEntry, e duEntry, n int) []duEntry {
+	i := sort.Search(len(list), func(i int) bool {
+		return list[i].Bytes < e.Bytes ||
+			(list[i].Bytes == e.Bytes && list[i].Path > e.Path)
+	})
+
+	if i >= n {
+		return list
+	}
+
+	list = append(list, duEntry{})
+	copy(list[i+1:], list[i:])
+	list[i] = e
+
+	if len(list) > n {
+		list = list[:n]
+	}
+
+	return list
+}
+
+func humanBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
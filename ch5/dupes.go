@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+type dupeFile struct {
+	path string
+	info os.FileInfo
+}
+
+// runDupes finds files with identical content. Files are grouped by size
+// first, so only files sharing a size are hashed. Depending on
+// cfg.dupesAction all but the first copy of each set are then deleted,
+// replaced by hard links or archived and deleted. Deletes go through rm,
+// so they use the trash when there is one, and are confirmed with ask in
+// confirm mode.
+func runDupes(root string, out io.Writer, cfg config,
+	visit func(string, os.FileInfo, error) (bool, error),
+	ask func(string) (bool, error),
+	arc archiver, rm *remover, rec *recorder) error {
+
+	switch cfg.dupesAction {
+	case "", "delete", "hardlink":
+	case "archive":
+		if arc == nil {
+			return fmt.Errorf("dupes action archive requires an archive directory")
+		}
+	default:
+		return fmt.Errorf("invalid dupes action %q: use delete, hardlink or archive", cfg.dupesAction)
+	}
+
+	bySize := make(map[int64][]dupeFile)
+
+	err := filepath.Walk(root,
+		func(path string, info os.FileInfo, err error) error {
+			if ok, err := visit(path, info, err); !ok {
+				return err
+			}
+
+			if !info.Mode().IsRegular() || info.Size() == 0 {
+				return nil
+			}
+
+			// Hard links to an already seen file are not duplicates.
+			for _, f := range bySize[info.Size()] {
+				if os.SameFile(f.info, info) {
+					return nil
+				}
+			}
+
+			bySize[info.Size()] = append(bySize[info.Size()], dupeFile{path: path, info: info})
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+
+	var sets [][]dupeFile
+	for _, files := range bySize {
+		if len(files) < 2 {
+			continue
+		}
+
+		byHash := make(map[string][]dupeFile)
+		for _, f := range files {
+			sum, err := hashFile(f.path)
+			if err != nil {
+				if err := rec.fail(f.path, f.info, err); err != nil {
+					return err
+				}
+				continue
+			}
+			byHash[sum] = append(byHash[sum], f)
+		}
+
+		for _, set := range byHash {
+			if len(set) > 1 {
+				sort.Slice(set, func(i, j int) bool { return set[i].path < set[j].path })
+				sets = append(sets, set)
+			}
+		}
+	}
+
+	sort.Slice(sets, func(i, j int) bool { return sets[i][0].path < sets[j][0].path })
+
+	var (
+		nDupes int
+		bytes  int64
+	)
+
+	for _, set := range sets {
+		size := set[0].info.Size()
+		if _, err := fmt.Fprintf(out, "%d copies, %d bytes each:\n", len(set), size); err != nil {
+			return err
+		}
+
+		for _, f := range set {
+			if _, err := fmt.Fprintf(out, "  %s\n", f.path); err != nil {
+				return err
+			}
+		}
+
+		nDupes += len(set) - 1
+		bytes += int64(len(set)-1) * size
+
+		if cfg.dupesAction == "" {
+			continue
+		}
+
+		keep := set[0]
+		for _, f := range set[1:] {
+			if err := dupeAction(root, keep, f, out, cfg, ask, arc, rm, rec); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = fmt.Fprintf(out, "%d duplicate sets, %d redundant files, %d bytes\n", len(sets), nDupes, bytes)
+	return err
+}
+
+func dupeAction(root string, keep, f dupeFile, out io.Writer, cfg config,
+	ask func(string) (bool, error),
+	arc archiver, rm *remover, rec *recorder) error {
+
+	size := f.info.Size()
+	deletes := cfg.dupesAction == "delete" || cfg.dupesAction == "archive"
+
+	if deletes && cfg.confirm != "" {
+		ok, err := ask(f.path)
+		if err != nil {
+			return rec.fail(f.path, f.info, err)
+		}
+		if !ok {
+			return rec.record(event{Action: "skipped", Path: f.path, Size: size})
+		}
+	}
+
+	if cfg.dryRun {
+		action := cfg.dupesAction
+		if action == "delete" && cfg.trash != "" {
+			action = "move to trash"
+		}
+
+		_, err := fmt.Fprintf(out, "would %s: %s\n", action, f.path)
+		return err
+	}
+
+	switch cfg.dupesAction {
+	case "hardlink":
+		if err := linkFile(keep.path, f.path); err != nil {
+			return rec.fail(f.path, f.info, err)
+		}
+		return rec.record(event{Action: "linked", Path: f.path, Size: size, Source: keep.path, Target: f.path})
+	case "archive":
+		if err := arc.add(root, f.path); err != nil {
+			return rec.fail(f.path, f.info, err)
+		}
+
+		target, _ := arc.target(root, f.path)
+		if err := rec.record(event{Action: "archived", Path: f.path, Size: size, Source: f.path, Target: target}); err != nil {
+			return err
+		}
+	}
+
+	return rm.remove(f.path, f.info)
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// linkFile replaces path with a hard link to target. The link is created
+// under a temporary name first so path is never missing.
+func linkFile(target, path string) error {
+	tmp := path + ".ch5link"
+	if err := os.Link(target, tmp); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
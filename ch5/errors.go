@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrPartial = errors.New("some files failed")
+
+type fileErr struct {
+	path  string
+	cause error
+}
+
+// partialErr reports every file that failed during a run that kept going
+// after errors.
+type partialErr struct {
+	failures []fileErr
+}
+
+func (p *partialErr) Error() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%v: %d failures", ErrPartial, len(p.failures))
+	for _, f := range p.failures {
+		fmt.Fprintf(&b, "\n  %s: %v", f.path, f.cause)
+	}
+
+	return b.String()
+}
+
+func (p *partialErr) Unwrap() error {
+	return ErrPartial
+}
@@ -1,3 +1,5 @@
 module achristie.net/ch5
 
 go 1.19
+
+require github.com/klauspost/compress v1.17.6
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"bufio"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 var (
@@ -15,12 +19,33 @@ var (
 )
 
 type config struct {
-	ext     string
-	size    int64
-	list    bool
-	del     bool
-	wLog    io.Writer
-	archive string
+	ext         string
+	size        int64
+	list        bool
+	del         bool
+	wLog        io.Writer
+	archive     string
+	format      string
+	level       int
+	dryRun      bool
+	confirm     string
+	restore     string
+	conflict    string
+	trash       string
+	trashCmd    string
+	batch       string
+	expire      time.Duration
+	jsonLog     io.Writer
+	summary     string
+	keepGo      bool
+	dupes       bool
+	dupesAction string
+	du          bool
+	depth       int
+	top         int
+	duFormat    string
+	warn        io.Writer
+	in          io.Reader
 }
 
 func main() {
@@ -31,9 +56,49 @@ func main() {
 	del := flag.Bool("del", false, "delete files")
 	logFile := flag.String("log", "", "log deletes to this file")
 	archive := flag.String("archive", "", "archive directory")
+	format := flag.String("format", "gz", "archive format: gz, zst, tar.gz, tar.zst or zip")
+	level := flag.Int("level", 0, "compression level (0 = default)")
+	dryRun := flag.Bool("dry-run", false, "show what would be archived or deleted")
+	confirm := flag.String("confirm", "", "ask before deleting: 'file' or 'dir'")
+	restore := flag.String("restore", "", "restore files from this archive directory into root")
+	conflict := flag.String("conflict", "skip", "restore policy for existing files: skip, overwrite or rename")
+	jsonLogFile := flag.String("json-log", "", "log every action as JSON lines to this file")
+	summary := flag.String("summary", "", "print a run summary: table or json")
+	keepGoing := flag.Bool("keep-going", false, "continue after errors and report all failures at the end")
+	dupes := flag.Bool("dupes", false, "find duplicate files")
+	dupesAction := flag.String("dupes-action", "", "for all but one copy of duplicates: delete, hardlink or archive")
+	du := flag.Bool("du", false, "summarize disk usage of matched files")
+	depth := flag.Int("depth", 1, "directory depth for disk usage")
+	top := flag.Int("top", 10, "number of largest directories and files to show")
+	duFormat := flag.String("du-format", "table", "disk usage output: table or json")
+	trash := flag.String("trash", "", "move deleted files to this trash directory")
+	trashLs := flag.Bool("trash-list", false, "list batches in the trash directory")
+	trashRestore := flag.String("trash-restore", "", "restore this batch from the trash directory")
+	trashExpire := flag.Duration("trash-expire", 0, "remove trash batches older than this")
 
 	flag.Parse()
 
+	var jsonLog io.Writer
+	if *jsonLogFile != "" {
+		jf, err := os.OpenFile(*jsonLogFile, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer jf.Close()
+		jsonLog = jf
+	}
+
+	trashCmd := ""
+	switch {
+	case *trashLs:
+		trashCmd = "list"
+	case *trashRestore != "":
+		trashCmd = "restore"
+	case *trashExpire > 0:
+		trashCmd = "expire"
+	}
+
 	if *logFile != "" {
 		f, err = os.OpenFile(*logFile, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
 		if err != nil {
@@ -44,47 +109,247 @@ func main() {
 	}
 
 	c := config{
-		ext:     *ext,
-		size:    *size,
-		list:    *list,
-		del:     *del,
-		wLog:    f,
-		archive: *archive,
+		ext:         *ext,
+		size:        *size,
+		list:        *list,
+		del:         *del,
+		wLog:        f,
+		archive:     *archive,
+		format:      *format,
+		level:       *level,
+		dryRun:      *dryRun,
+		confirm:     *confirm,
+		restore:     *restore,
+		conflict:    *conflict,
+		trash:       *trash,
+		trashCmd:    trashCmd,
+		batch:       *trashRestore,
+		expire:      *trashExpire,
+		jsonLog:     jsonLog,
+		summary:     *summary,
+		keepGo:      *keepGoing,
+		dupes:       *dupes,
+		dupesAction: *dupesAction,
+		du:          *du,
+		depth:       *depth,
+		top:         *top,
+		duFormat:    *duFormat,
+		warn:        os.Stderr,
+		in:          os.Stdin,
 	}
 
 	if err := run(*root, os.Stdout, c); err != nil {
 		fmt.Fprintln(os.Stderr, err)
+		if errors.Is(err, ErrPartial) {
+			os.Exit(2)
+		}
 		os.Exit(1)
 	}
 }
 
 func run(root string, out io.Writer, cfg config) error {
+	if cfg.restore != "" {
+		return restoreArchive(cfg.restore, root, out, cfg)
+	}
+
+	if cfg.trashCmd != "" {
+		return runTrash(out, cfg)
+	}
+
 	delLogger := log.New(cfg.wLog, "DELETED FILE:", log.LstdFlags)
 
-	return filepath.Walk(root,
-		func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
+	if cfg.confirm != "" && cfg.confirm != "file" && cfg.confirm != "dir" {
+		return fmt.Errorf("invalid confirm mode %q: use 'file' or 'dir'", cfg.confirm)
+	}
+
+	if cfg.summary != "" && cfg.summary != "table" && cfg.summary != "json" {
+		return fmt.Errorf("invalid summary format %q: use table or json", cfg.summary)
+	}
+
+	var arc archiver
+	if cfg.archive != "" {
+		var err error
+		arc, err = newArchiver(cfg.archive, cfg.format, cfg.level)
+		if err != nil {
+			return err
+		}
+	}
 
-			if filterOut(path, cfg.ext, cfg.size, info) {
-				return nil
+	// The batch directory is only created once a file is moved, so runs
+	// that delete nothing leave no empty batch behind.
+	var trash *trashBatch
+	if cfg.trash != "" && !cfg.dryRun {
+		trash = newTrashBatch(cfg.trash)
+	}
+
+	var in *bufio.Reader
+	if cfg.in != nil {
+		in = bufio.NewReader(cfg.in)
+	}
+	dirAnswers := make(map[string]bool)
+	ask := func(path string) (bool, error) {
+		return confirmFile(path, cfg, in, out, dirAnswers)
+	}
+
+	var tot dryRunTotals
+
+	// Never walk into the archive or trash directories when they live
+	// under root.
+	skipDirs := make(map[string]bool)
+	for _, d := range []string{cfg.archive, cfg.trash} {
+		if d == "" {
+			continue
+		}
+
+		abs, err := filepath.Abs(d)
+		if err != nil {
+			return err
+		}
+		skipDirs[abs] = true
+	}
+
+	rec := newRecorder(cfg.jsonLog)
+	rec.keepGoing = cfg.keepGo
+
+	// visit handles walk errors, skipped directories and filters. It
+	// reports whether path is a matched file to act on.
+	visit := func(path string, info os.FileInfo, err error) (bool, error) {
+		if err != nil {
+			if cfg.keepGo && info != nil && info.IsDir() && errors.Is(err, fs.ErrPermission) {
+				if cfg.warn != nil {
+					fmt.Fprintf(cfg.warn, "warning: skipping %s: %v\n", path, err)
+				}
+				return false, rec.record(event{Action: "skipped", Path: path, Error: err.Error()})
 			}
+			return false, rec.fail(path, info, err)
+		}
 
-			if cfg.list {
-				return listFile(path, out)
+		if info.IsDir() && len(skipDirs) > 0 {
+			if abs, err := filepath.Abs(path); err == nil && skipDirs[abs] {
+				return false, filepath.SkipDir
 			}
+		}
+
+		return !filterOut(path, cfg.ext, cfg.size, info), nil
+	}
+
+	// Files archived into a bundle are deleted only once the bundle has
+	// been written completely.
+	rm := &remover{root: root, trash: trash, delLogger: delLogger, rec: rec, deferred: bundled(arc)}
 
-			if cfg.archive != "" {
-				if err := archiveFile(cfg.archive, root, path); err != nil {
+	var err error
+	if cfg.dupes {
+		err = runDupes(root, out, cfg, visit, ask, arc, rm, rec)
+	} else if cfg.du {
+		err = runDu(root, out, cfg, visit)
+	} else {
+		err = filepath.Walk(root,
+			func(path string, info os.FileInfo, err error) error {
+				if ok, err := visit(path, info, err); !ok {
 					return err
 				}
-			}
 
-			if cfg.del {
-				return delFile(path, delLogger)
-			}
+				if cfg.list {
+					if err := listFile(path, out); err != nil {
+						return rec.fail(path, info, err)
+					}
+					return rec.record(event{Action: "listed", Path: path, Size: info.Size()})
+				}
+
+				if cfg.confirm != "" && cfg.del {
+					ok, err := ask(path)
+					if err != nil {
+						return rec.fail(path, info, err)
+					}
+					if !ok {
+						return rec.record(event{Action: "skipped", Path: path, Size: info.Size()})
+					}
+				}
+
+				if cfg.dryRun {
+					return dryRunFile(root, path, info, out, cfg, arc, &tot)
+				}
+
+				if arc != nil {
+					if err := arc.add(root, path); err != nil {
+						return rec.fail(path, info, err)
+					}
+
+					target, _ := arc.target(root, path)
+					if err := rec.record(event{Action: "archived", Path: path, Size: info.Size(), Source: path, Target: target}); err != nil {
+						return err
+					}
+				}
+
+				if cfg.del {
+					return rm.remove(path, info)
+				}
+
+				if err := listFile(path, out); err != nil {
+					return rec.fail(path, info, err)
+				}
+				return rec.record(event{Action: "listed", Path: path, Size: info.Size()})
+			})
+	}
+
+	if arc != nil {
+		cerr := arc.Close()
+		if cerr != nil && len(rm.queue) > 0 {
+			cerr = fmt.Errorf("%d archived files were not deleted: %w", len(rm.queue), cerr)
+		}
+		if cerr == nil {
+			cerr = rm.flush()
+		}
+		if err == nil {
+			err = cerr
+		}
+	}
+
+	if trash != nil {
+		if cerr := trash.Close(); err == nil {
+			err = cerr
+		}
+	}
+
+	if err != nil {
+		if cfg.summary != "" {
+			printSummary(out, rec.summary(), cfg.summary)
+		}
+		return err
+	}
+
+	if cfg.dryRun && !cfg.list && (cfg.archive != "" || cfg.del) {
+		if err := tot.print(out); err != nil {
+			return err
+		}
+	}
+
+	if cfg.summary != "" {
+		if err := printSummary(out, rec.summary(), cfg.summary); err != nil {
+			return err
+		}
+	}
+
+	if len(rec.failures) > 0 {
+		return &partialErr{failures: rec.failures}
+	}
+
+	return nil
+}
+
+func runTrash(out io.Writer, cfg config) error {
+	if cfg.trash == "" {
+		return fmt.Errorf("trash directory is required")
+	}
+
+	switch cfg.trashCmd {
+	case "list":
+		return trashList(cfg.trash, out)
+	case "restore":
+		return trashRestore(cfg.trash, cfg.batch, out, cfg)
+	case "expire":
+		return trashExpire(cfg.trash, cfg.expire, time.Now(), out, cfg.dryRun)
+	}
 
-			return listFile(path, out)
-		})
+	return fmt.Errorf("invalid trash command %q", cfg.trashCmd)
 }
@@ -1,13 +1,22 @@
 package main
 
 import (
+	"archive/tar"
+	"archive/zip"
 	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
 )
 
 func createTempDir(t *testing.T, files map[string]int) (dirname string, cleanup func()) {
@@ -175,3 +184,1183 @@ func TestRunArchive(t *testing.T) {
 		})
 	}
 }
+
+func TestRunDryRun(t *testing.T) {
+	var (
+		buffer    bytes.Buffer
+		logBuffer bytes.Buffer
+	)
+
+	tempDir, cleanup := createTempDir(t, map[string]int{".log": 3, ".gz": 2})
+	defer cleanup()
+
+	archiveDir, cleanupArchive := createTempDir(t, nil)
+	defer cleanupArchive()
+
+	cfg := config{ext: ".log", del: true, archive: archiveDir, dryRun: true, wLog: &logBuffer}
+	if err := run(tempDir, &buffer, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 5 {
+		t.Errorf("Expected 5 files left, got %d instead\n", len(files))
+	}
+
+	archived, err := os.ReadDir(archiveDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archived) != 0 {
+		t.Errorf("Expected no files archived, got %d instead\n", len(archived))
+	}
+
+	if logBuffer.Len() != 0 {
+		t.Errorf("Expected empty delete log, got %q instead\n", logBuffer.String())
+	}
+
+	res := buffer.String()
+	if n := strings.Count(res, "would archive: "); n != 3 {
+		t.Errorf("Expected 3 archive lines, got %d instead\n", n)
+	}
+	if n := strings.Count(res, "would delete: "); n != 3 {
+		t.Errorf("Expected 3 delete lines, got %d instead\n", n)
+	}
+
+	expSummary := "dry run: 3 files (30 bytes) would be archived, 3 files (30 bytes) would be deleted\n"
+	if !strings.HasSuffix(res, expSummary) {
+		t.Errorf("Expected summary %q, got %q instead\n", expSummary, res)
+	}
+}
+
+func TestRunConfirm(t *testing.T) {
+	tests := []struct {
+		name     string
+		confirm  string
+		input    string
+		nDelete  int
+		nPrompts int
+		expErr   bool
+	}{
+		{name: "ConfirmFileAll", confirm: "file", input: "y\ny\ny\n", nDelete: 3, nPrompts: 3},
+		{name: "ConfirmFileSome", confirm: "file", input: "y\nn\nyes\n", nDelete: 2, nPrompts: 3},
+		{name: "ConfirmFileEOF", confirm: "file", input: "y\n", nDelete: 1, nPrompts: 3},
+		{name: "ConfirmDirYes", confirm: "dir", input: "y\n", nDelete: 3, nPrompts: 1},
+		{name: "ConfirmDirNo", confirm: "dir", input: "n\n", nDelete: 0, nPrompts: 1},
+		{name: "ConfirmInvalid", confirm: "all", input: "", expErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				buffer    bytes.Buffer
+				logBuffer bytes.Buffer
+			)
+
+			tempDir, cleanup := createTempDir(t, map[string]int{".log": 3})
+			defer cleanup()
+
+			cfg := config{
+				ext:     ".log",
+				del:     true,
+				confirm: tt.confirm,
+				in:      strings.NewReader(tt.input),
+				wLog:    &logBuffer,
+			}
+
+			err := run(tempDir, &buffer, cfg)
+			if tt.expErr {
+				if err == nil {
+					t.Fatal("Expected error, got nil instead")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if n := strings.Count(buffer.String(), "[y/N]: "); n != tt.nPrompts {
+				t.Errorf("Expected %d prompts, got %d instead\n", tt.nPrompts, n)
+			}
+
+			filesLeft, err := os.ReadDir(tempDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(filesLeft) != 3-tt.nDelete {
+				t.Errorf("Expected %d files left, got %d instead\n", 3-tt.nDelete, len(filesLeft))
+			}
+		})
+	}
+}
+
+func TestRunArchiveFormats(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		level  int
+		nFiles int
+		bundle bool
+		expErr bool
+	}{
+		{name: "Gzip", format: "gz", level: 9, nFiles: 3},
+		{name: "Zstd", format: "zst", nFiles: 3},
+		{name: "TarGzip", format: "tar.gz", level: 1, nFiles: 1, bundle: true},
+		{name: "TarZstd", format: "tar.zst", level: 19, nFiles: 1, bundle: true},
+		{name: "Zip", format: "zip", level: 9, nFiles: 1, bundle: true},
+		{name: "InvalidFormat", format: "rar", expErr: true},
+		{name: "InvalidLevel", format: "tar.gz", level: 12, expErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buffer bytes.Buffer
+
+			tempDir, cleanup := createTempDir(t, map[string]int{".log": 3, ".txt": 2})
+			defer cleanup()
+
+			archiveDir, cleanupArchive := createTempDir(t, nil)
+			defer cleanupArchive()
+
+			cfg := config{ext: ".log", archive: archiveDir, format: tt.format, level: tt.level}
+
+			err := run(tempDir, &buffer, cfg)
+			if tt.expErr {
+				if err == nil {
+					t.Fatal("Expected error, got nil instead")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			filesArchived, err := os.ReadDir(archiveDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(filesArchived) != tt.nFiles {
+				t.Fatalf("Expected %d files archived, got %d instead\n", tt.nFiles, len(filesArchived))
+			}
+
+			if !tt.bundle {
+				for _, f := range filesArchived {
+					if filepath.Ext(f.Name()) != "."+tt.format {
+						t.Errorf("Expected extension %q, got %q instead\n", tt.format, f.Name())
+					}
+				}
+				return
+			}
+
+			contents := readBundle(t, filepath.Join(archiveDir, filesArchived[0].Name()))
+
+			exp := map[string]string{
+				"file1.log": "dummy data",
+				"file2.log": "dummy data",
+				"file3.log": "dummy data",
+			}
+
+			if len(contents) != len(exp) {
+				t.Errorf("Expected %d entries, got %d instead\n", len(exp), len(contents))
+			}
+
+			for name, data := range exp {
+				if contents[name] != data {
+					t.Errorf("Expected %q for %s, got %q instead\n", data, name, contents[name])
+				}
+			}
+		})
+	}
+}
+
+func TestRunArchiveBundleDelete(t *testing.T) {
+	for _, format := range []string{"tar.gz", "tar.zst", "zip"} {
+		t.Run(format, func(t *testing.T) {
+			var jsonLog bytes.Buffer
+
+			tempDir, cleanup := createTempDir(t, map[string]int{".log": 3, ".txt": 2})
+			defer cleanup()
+
+			archiveDir, cleanupArchive := createTempDir(t, nil)
+			defer cleanupArchive()
+
+			cfg := config{ext: ".log", archive: archiveDir, format: format, del: true, wLog: io.Discard, jsonLog: &jsonLog}
+			if err := run(tempDir, io.Discard, cfg); err != nil {
+				t.Fatal(err)
+			}
+
+			// Originals are deleted only after the bundle is complete, so
+			// every file is archived before the first delete.
+			var actions []string
+			dec := json.NewDecoder(&jsonLog)
+			for dec.More() {
+				var e event
+				if err := dec.Decode(&e); err != nil {
+					t.Fatal(err)
+				}
+				actions = append(actions, e.Action)
+			}
+
+			exp := "archived archived archived deleted deleted deleted"
+			if res := strings.Join(actions, " "); res != exp {
+				t.Errorf("Expected actions %q, got %q instead\n", exp, res)
+			}
+
+			bundles, err := filepath.Glob(filepath.Join(archiveDir, "archive-*"))
+			if err != nil || len(bundles) != 1 {
+				t.Fatalf("Expected 1 bundle, got %v (%v) instead\n", bundles, err)
+			}
+
+			if contents := readBundle(t, bundles[0]); len(contents) != 3 {
+				t.Errorf("Expected 3 entries, got %d instead\n", len(contents))
+			}
+
+			if logs, _ := filepath.Glob(filepath.Join(tempDir, "*.log")); len(logs) != 0 {
+				t.Errorf("Expected originals to be deleted, got %v instead\n", logs)
+			}
+		})
+	}
+}
+
+func readBundle(t *testing.T, path string) map[string]string {
+	t.Helper()
+
+	contents := make(map[string]string)
+
+	if strings.HasSuffix(path, ".zip") {
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			contents[f.Name] = string(data)
+		}
+
+		return contents
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var r io.Reader
+	if strings.HasSuffix(path, ".tar.gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = zr
+	} else {
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		contents[hdr.Name] = string(data)
+	}
+
+	return contents
+}
+
+func TestRunRestore(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   string
+		conflict string
+		existing bool
+		expData  string
+		nFiles   int
+		expErr   bool
+	}{
+		{name: "RestoreNoConflict", conflict: "skip", expData: "dummy data", nFiles: 2},
+		{name: "RestoreTarGzip", format: "tar.gz", conflict: "skip", expData: "dummy data", nFiles: 2},
+		{name: "RestoreTarZstd", format: "tar.zst", conflict: "skip", expData: "dummy data", nFiles: 2},
+		{name: "RestoreZip", format: "zip", conflict: "skip", expData: "dummy data", nFiles: 2},
+		{name: "RestoreZipRename", format: "zip", conflict: "rename", existing: true, expData: "newer", nFiles: 3},
+		{name: "RestoreSkip", conflict: "skip", existing: true, expData: "newer", nFiles: 2},
+		{name: "RestoreOverwrite", conflict: "overwrite", existing: true, expData: "dummy data", nFiles: 2},
+		{name: "RestoreRename", conflict: "rename", existing: true, expData: "newer", nFiles: 3},
+		{name: "RestoreInvalidPolicy", conflict: "merge", expErr: true},
+	}
+
+	modTime := time.Date(2020, time.January, 2, 3, 4, 5, 0, time.UTC)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buffer bytes.Buffer
+
+			tempDir, cleanup := createTempDir(t, map[string]int{".log": 1})
+			defer cleanup()
+
+			subDir := filepath.Join(tempDir, "sub")
+			if err := os.Mkdir(subDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(subDir, "file2.log"), []byte("dummy data"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, p := range []string{filepath.Join(tempDir, "file1.log"), filepath.Join(subDir, "file2.log")} {
+				if err := os.Chtimes(p, modTime, modTime); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			archiveDir, cleanupArchive := createTempDir(t, nil)
+			defer cleanupArchive()
+
+			cfg := config{ext: ".log", archive: archiveDir, format: tt.format, del: true, wLog: io.Discard}
+			if err := run(tempDir, &buffer, cfg); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.existing {
+				if err := os.WriteFile(filepath.Join(tempDir, "file1.log"), []byte("newer"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cfg = config{restore: archiveDir, conflict: tt.conflict}
+			err := run(tempDir, &buffer, cfg)
+			if tt.expErr {
+				if err == nil {
+					t.Fatal("Expected error, got nil instead")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(filepath.Join(tempDir, "file1.log"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.expData {
+				t.Errorf("Expected %q, got %q instead\n", tt.expData, string(data))
+			}
+
+			info, err := os.Stat(filepath.Join(subDir, "file2.log"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !info.ModTime().Equal(modTime) {
+				t.Errorf("Expected mod time %v, got %v instead\n", modTime, info.ModTime())
+			}
+
+			restored, err := filepath.Glob(filepath.Join(tempDir, "*", "*.log"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			top, err := filepath.Glob(filepath.Join(tempDir, "*.log"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := len(restored) + len(top); n != tt.nFiles {
+				t.Errorf("Expected %d files, got %d instead\n", tt.nFiles, n)
+			}
+
+			if stray, _ := filepath.Glob(filepath.Join(tempDir, "archive-*")); len(stray) != 0 {
+				t.Errorf("Expected no bundle in the root, got %v instead\n", stray)
+			}
+		})
+	}
+}
+
+func TestRunRestoreTar(t *testing.T) {
+	// A tar file archived on its own is named like a tar bundle.
+	var tarData bytes.Buffer
+	tw := tar.NewWriter(&tarData)
+	if err := tw.WriteHeader(&tar.Header{Name: "inner/a.txt", Mode: 0644, Size: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write([]byte("inner")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{"gz", "zst"} {
+		t.Run(format, func(t *testing.T) {
+			var buffer bytes.Buffer
+
+			tempDir, cleanup := createTempDir(t, nil)
+			defer cleanup()
+			if err := os.WriteFile(filepath.Join(tempDir, "logs.tar"), tarData.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			archiveDir, cleanupArchive := createTempDir(t, nil)
+			defer cleanupArchive()
+
+			cfg := config{ext: ".tar", archive: archiveDir, format: format, del: true, wLog: io.Discard}
+			if err := run(tempDir, &buffer, cfg); err != nil {
+				t.Fatal(err)
+			}
+
+			outDir, cleanupOut := createTempDir(t, nil)
+			defer cleanupOut()
+
+			if err := run(outDir, &buffer, config{restore: archiveDir, conflict: "skip"}); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(filepath.Join(outDir, "logs.tar"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, tarData.Bytes()) {
+				t.Errorf("Expected the tar file restored as it was, got %d bytes instead\n", len(data))
+			}
+
+			if _, err := os.Stat(filepath.Join(outDir, "inner")); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("Expected the tar file not to be unpacked, got %v instead\n", err)
+			}
+		})
+	}
+}
+
+func TestIsBundle(t *testing.T) {
+	tests := []struct {
+		name string
+		exp  bool
+	}{
+		{name: "archive-20240102-030405.tar.gz", exp: true},
+		{name: "archive-20240102-030405.tar.zst", exp: true},
+		{name: "archive-20240102-030405.zip", exp: true},
+		{name: "logs.tar.gz"},
+		{name: "logs.tar.zst"},
+		{name: "logs.zip.gz"},
+		{name: "archive-old.tar.gz"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if res := isBundle(filepath.Join("arc", tt.name)); res != tt.exp {
+				t.Errorf("Expected %t, got %t instead\n", tt.exp, res)
+			}
+		})
+	}
+}
+
+func TestBundleTarget(t *testing.T) {
+	tests := []struct {
+		name   string
+		exp    string
+		expErr bool
+	}{
+		{name: "file1.log", exp: filepath.Join("root", "file1.log")},
+		{name: "sub/file2.log", exp: filepath.Join("root", "sub", "file2.log")},
+		{name: "sub/../file3.log", exp: filepath.Join("root", "file3.log")},
+		{name: "../escape.log", expErr: true},
+		{name: "sub/../../escape.log", expErr: true},
+		{name: "/etc/passwd", expErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, err := bundleTarget("root", "archive.zip", tt.name)
+			if tt.expErr {
+				if err == nil {
+					t.Fatalf("Expected error, got %q instead", target)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if target != tt.exp {
+				t.Errorf("Expected %q, got %q instead\n", tt.exp, target)
+			}
+		})
+	}
+}
+
+func TestRunTrash(t *testing.T) {
+	var (
+		buffer    bytes.Buffer
+		logBuffer bytes.Buffer
+	)
+
+	tempDir, cleanup := createTempDir(t, map[string]int{".log": 3, ".txt": 2})
+	defer cleanup()
+
+	trashDir := filepath.Join(tempDir, "trash")
+	if err := os.Mkdir(trashDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config{ext: ".log", del: true, trash: trashDir, wLog: &logBuffer}
+	if err := run(tempDir, &buffer, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	left, err := filepath.Glob(filepath.Join(tempDir, "*.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 0 {
+		t.Errorf("Expected no .log files left, got %d instead\n", len(left))
+	}
+
+	if n := strings.Count(logBuffer.String(), "DELETED FILE:"); n != 3 {
+		t.Errorf("Expected 3 log lines, got %d instead\n", n)
+	}
+
+	batches, err := listBatches(trashDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 1 {
+		t.Fatalf("Expected 1 batch, got %d instead\n", len(batches))
+	}
+	if n := len(batches[0].manifest.Files); n != 3 {
+		t.Errorf("Expected 3 files in manifest, got %d instead\n", n)
+	}
+	if _, err := os.Stat(filepath.Join(trashDir, batches[0].id, journalName)); !os.IsNotExist(err) {
+		t.Errorf("Expected the journal to be removed on close, got %v instead\n", err)
+	}
+
+	buffer.Reset()
+	cfg = config{trash: trashDir, trashCmd: "list"}
+	if err := run(tempDir, &buffer, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buffer.String(), batches[0].id+"\t") || !strings.Contains(buffer.String(), "3 files\t30 bytes") {
+		t.Errorf("Unexpected list output %q\n", buffer.String())
+	}
+
+	if err := os.WriteFile(filepath.Join(tempDir, "file1.log"), []byte("newer"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	buffer.Reset()
+	cfg = config{trash: trashDir, trashCmd: "restore", batch: batches[0].id, conflict: "skip", dryRun: true}
+	if err := run(tempDir, &buffer, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(buffer.String(), "would restore:"); n != 2 {
+		t.Errorf("Expected 2 files to be restored, got %q instead\n", buffer.String())
+	}
+	if left, _ := filepath.Glob(filepath.Join(tempDir, "*.log")); len(left) != 1 {
+		t.Errorf("Expected a dry run to restore nothing, got %v instead\n", left)
+	}
+
+	buffer.Reset()
+	cfg.dryRun = false
+	if err := run(tempDir, &buffer, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := filepath.Glob(filepath.Join(tempDir, "*.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored) != 3 {
+		t.Errorf("Expected 3 .log files after restore, got %d instead\n", len(restored))
+	}
+
+	batches, err = listBatches(trashDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 1 || len(batches[0].manifest.Files) != 1 {
+		t.Fatalf("Expected skipped file to stay in the trash, got %v\n", batches)
+	}
+
+	buffer.Reset()
+	if err := trashExpire(trashDir, time.Hour, time.Now(), &buffer, false); err != nil {
+		t.Fatal(err)
+	}
+	if buffer.Len() != 0 {
+		t.Errorf("Expected nothing expired, got %q\n", buffer.String())
+	}
+
+	if err := trashExpire(trashDir, time.Hour, time.Now().Add(2*time.Hour), &buffer, true); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buffer.String(), "would expire: "+batches[0].id) {
+		t.Errorf("Expected the batch to be expired, got %q instead\n", buffer.String())
+	}
+	if batches, err := listBatches(trashDir); err != nil || len(batches) != 1 {
+		t.Errorf("Expected a dry run to keep the batch, got %v (%v) instead\n", batches, err)
+	}
+
+	if err := trashExpire(trashDir, time.Hour, time.Now().Add(2*time.Hour), &buffer, false); err != nil {
+		t.Fatal(err)
+	}
+
+	batches, err = listBatches(trashDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 0 {
+		t.Errorf("Expected all batches expired, got %d instead\n", len(batches))
+	}
+}
+
+func TestTrashInterrupted(t *testing.T) {
+	tempDir, cleanup := createTempDir(t, map[string]int{".log": 2})
+	defer cleanup()
+
+	trashDir := filepath.Join(tempDir, "trash")
+	if err := os.Mkdir(trashDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// A run killed part-way never closes its batch.
+	batch := newTrashBatch(trashDir)
+	for _, name := range []string{"file1.log", "file2.log"} {
+		if err := batch.add(tempDir, filepath.Join(tempDir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	batch.journal.Close()
+
+	batches, err := listBatches(trashDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 1 || len(batches[0].manifest.Files) != 2 {
+		t.Fatalf("Expected 1 batch with 2 files, got %+v instead\n", batches)
+	}
+
+	var buffer bytes.Buffer
+	cfg := config{trash: trashDir, trashCmd: "restore", batch: batches[0].id, conflict: "skip"}
+	if err := run(tempDir, &buffer, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := strings.Count(buffer.String(), "restored:"); n != 2 {
+		t.Errorf("Expected 2 files restored, got %q instead\n", buffer.String())
+	}
+
+	if _, err := os.Stat(filepath.Join(trashDir, batches[0].id)); !os.IsNotExist(err) {
+		t.Errorf("Expected the restored batch to be removed, got %v instead\n", err)
+	}
+}
+
+func TestTrashRestorePartial(t *testing.T) {
+	tempDir, cleanup := createTempDir(t, nil)
+	defer cleanup()
+
+	for _, name := range []string{"a", "b"} {
+		if err := os.Mkdir(filepath.Join(tempDir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(tempDir, name, "file.log"), []byte("dummy data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	trashDir := filepath.Join(tempDir, "trash")
+	if err := os.Mkdir(trashDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config{ext: ".log", del: true, trash: trashDir, wLog: io.Discard}
+	if err := run(tempDir, io.Discard, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	batches, err := listBatches(trashDir)
+	if err != nil || len(batches) != 1 {
+		t.Fatalf("Expected 1 batch, got %v (%v) instead\n", batches, err)
+	}
+	id := batches[0].id
+
+	// A file in place of the directory b makes the second restore fail.
+	blocker := filepath.Join(tempDir, "b")
+	if err := os.Remove(blocker); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(blocker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg = config{trash: trashDir, trashCmd: "restore", batch: id, conflict: "overwrite"}
+	if err := run(tempDir, io.Discard, cfg); err == nil {
+		t.Fatal("Expected error, got nil instead")
+	}
+
+	if _, err := os.Stat(filepath.Join(tempDir, "a", "file.log")); err != nil {
+		t.Errorf("Expected the first file restored, got %v instead\n", err)
+	}
+
+	m, err := readManifest(filepath.Join(trashDir, id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Files) != 1 || m.Files[0].Original != filepath.Join(tempDir, "b", "file.log") {
+		t.Fatalf("Expected only the unrestored file in the batch, got %+v instead\n", m.Files)
+	}
+
+	if err := os.Remove(blocker); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(tempDir, io.Discard, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(tempDir, "b", "file.log")); err != nil {
+		t.Errorf("Expected the second file restored, got %v instead\n", err)
+	}
+	if _, err := os.Stat(filepath.Join(trashDir, id)); !os.IsNotExist(err) {
+		t.Errorf("Expected the restored batch to be removed, got %v instead\n", err)
+	}
+}
+
+func TestTrashRestoreInvalidConflict(t *testing.T) {
+	tempDir, cleanup := createTempDir(t, map[string]int{".log": 1})
+	defer cleanup()
+
+	trashDir := filepath.Join(tempDir, "trash")
+	if err := os.Mkdir(trashDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config{ext: ".log", del: true, trash: trashDir, wLog: io.Discard}
+	if err := run(tempDir, io.Discard, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	batches, err := listBatches(trashDir)
+	if err != nil || len(batches) != 1 {
+		t.Fatalf("Expected 1 batch, got %v (%v) instead\n", batches, err)
+	}
+
+	cfg = config{trash: trashDir, trashCmd: "restore", batch: batches[0].id, conflict: "overwirte"}
+	if err := run(tempDir, io.Discard, cfg); err == nil || !strings.Contains(err.Error(), "invalid conflict policy") {
+		t.Errorf("Expected invalid conflict policy error, got %v instead\n", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(tempDir, "file1.log")); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing restored, got %v instead\n", err)
+	}
+}
+
+func TestRunJSONLog(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		jsonLog bytes.Buffer
+	)
+
+	tempDir, cleanup := createTempDir(t, map[string]int{".log": 3, ".txt": 2})
+	defer cleanup()
+
+	archiveDir, cleanupArchive := createTempDir(t, nil)
+	defer cleanupArchive()
+
+	cfg := config{
+		ext:     ".log",
+		archive: archiveDir,
+		del:     true,
+		wLog:    io.Discard,
+		jsonLog: &jsonLog,
+		summary: "json",
+	}
+	if err := run(tempDir, &buffer, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	actions := make(map[string]int)
+	dec := json.NewDecoder(&jsonLog)
+	for dec.More() {
+		var e event
+		if err := dec.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+
+		actions[e.Action]++
+		if e.Size != 10 {
+			t.Errorf("Expected size 10, got %d instead\n", e.Size)
+		}
+		if e.Action == "archived" && (e.Source != e.Path || !strings.HasPrefix(e.Target, archiveDir)) {
+			t.Errorf("Unexpected archive event %+v\n", e)
+		}
+	}
+
+	if actions["archived"] != 3 || actions["deleted"] != 3 || len(actions) != 2 {
+		t.Errorf("Expected 3 archived and 3 deleted events, got %v instead\n", actions)
+	}
+
+	var s summary
+	if err := json.Unmarshal(buffer.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.BytesReclaimed != 30 {
+		t.Errorf("Expected 30 bytes reclaimed, got %d instead\n", s.BytesReclaimed)
+	}
+
+	for _, a := range s.Actions {
+		exp := 0
+		if a.Action == "archived" || a.Action == "deleted" {
+			exp = 3
+		}
+		if a.Files != exp {
+			t.Errorf("Expected %d %s files, got %d instead\n", exp, a.Action, a.Files)
+		}
+	}
+}
+
+func TestPrintSummaryTable(t *testing.T) {
+	var buffer bytes.Buffer
+
+	s := summary{
+		Actions:        []actionSummary{{Action: "listed", Files: 2, Bytes: 20}, {Action: "deleted", Files: 1, Bytes: 10}},
+		BytesReclaimed: 10,
+		Elapsed:        "5ms",
+	}
+
+	if err := printSummary(&buffer, s, "table"); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := "ACTION   FILES  BYTES\nlisted   2      20\ndeleted  1      10\nBytes reclaimed: 10\nElapsed: 5ms\n"
+	if buffer.String() != exp {
+		t.Errorf("Expected %q, got %q instead\n", exp, buffer.String())
+	}
+
+	if err := printSummary(&buffer, s, "xml"); err == nil {
+		t.Error("Expected error for invalid format, got nil instead")
+	}
+}
+
+func TestRunKeepGoing(t *testing.T) {
+	tests := []struct {
+		name      string
+		keepGoing bool
+		nLeft     int
+	}{
+		{name: "StopOnError", keepGoing: false, nLeft: 3},
+		{name: "KeepGoing", keepGoing: true, nLeft: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buffer bytes.Buffer
+
+			tempDir, cleanup := createTempDir(t, map[string]int{".log": 3})
+			defer cleanup()
+
+			archiveDir, cleanupArchive := createTempDir(t, nil)
+			defer cleanupArchive()
+
+			// A directory in place of the archive target makes file1 fail.
+			if err := os.Mkdir(filepath.Join(archiveDir, "file1.log.gz"), 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg := config{ext: ".log", archive: archiveDir, del: true, wLog: io.Discard, keepGo: tt.keepGoing}
+			err := run(tempDir, &buffer, cfg)
+			if err == nil {
+				t.Fatal("Expected error, got nil instead")
+			}
+
+			if tt.keepGoing != errors.Is(err, ErrPartial) {
+				t.Errorf("Expected ErrPartial %t, got %q instead\n", tt.keepGoing, err)
+			}
+
+			var pErr *partialErr
+			if tt.keepGoing {
+				if !errors.As(err, &pErr) || len(pErr.failures) != 1 {
+					t.Fatalf("Expected 1 failure, got %q instead\n", err)
+				}
+				if filepath.Base(pErr.failures[0].path) != "file1.log" {
+					t.Errorf("Expected failure for file1.log, got %s instead\n", pErr.failures[0].path)
+				}
+			}
+
+			filesLeft, err := os.ReadDir(tempDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(filesLeft) != tt.nLeft {
+				t.Errorf("Expected %d files left, got %d instead\n", tt.nLeft, len(filesLeft))
+			}
+		})
+	}
+}
+
+func TestRunKeepGoingPermission(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("Permissions are not enforced for root. Skipping test.")
+	}
+
+	var (
+		buffer  bytes.Buffer
+		warnBuf bytes.Buffer
+	)
+
+	tempDir, cleanup := createTempDir(t, map[string]int{".log": 2})
+	defer cleanup()
+
+	locked := filepath.Join(tempDir, "locked")
+	if err := os.Mkdir(locked, 0000); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(locked, 0755)
+
+	cfg := config{ext: ".log", list: true, keepGo: true, warn: &warnBuf}
+	if err := run(tempDir, &buffer, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(warnBuf.String(), "warning: skipping "+locked) {
+		t.Errorf("Expected warning for %s, got %q instead\n", locked, warnBuf.String())
+	}
+
+	if n := strings.Count(buffer.String(), "\n"); n != 2 {
+		t.Errorf("Expected 2 files listed, got %d instead\n", n)
+	}
+}
+
+func TestRunDupes(t *testing.T) {
+	tests := []struct {
+		name    string
+		action  string
+		trash   bool
+		confirm string
+		input   string
+		nLeft   int
+		nTrash  int
+		expErr  bool
+	}{
+		{name: "Report", action: "", nLeft: 4},
+		{name: "Delete", action: "delete", nLeft: 2},
+		{name: "Hardlink", action: "hardlink", nLeft: 4},
+		{name: "Archive", action: "archive", nLeft: 2},
+		{name: "DeleteTrash", action: "delete", trash: true, nLeft: 2, nTrash: 2},
+		{name: "ArchiveTrash", action: "archive", trash: true, nLeft: 2, nTrash: 2},
+		{name: "DeleteConfirm", action: "delete", confirm: "file", input: "y\nn\n", nLeft: 3},
+		{name: "DeleteConfirmTrash", action: "delete", trash: true, confirm: "file", input: "n\ny\n", nLeft: 3, nTrash: 1},
+		{name: "InvalidAction", action: "move", expErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				buffer    bytes.Buffer
+				logBuffer bytes.Buffer
+			)
+
+			tempDir, cleanup := createTempDir(t, nil)
+			defer cleanup()
+
+			files := map[string]string{
+				"a.log":     "same data",
+				"b.log":     "same data",
+				"c.log":     "diff data",
+				"sub/d.log": "same data",
+			}
+			for name, data := range files {
+				p := filepath.Join(tempDir, filepath.FromSlash(name))
+				if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(p, []byte(data), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			archiveDir, cleanupArchive := createTempDir(t, nil)
+			defer cleanupArchive()
+
+			trashDir, cleanupTrash := createTempDir(t, nil)
+			defer cleanupTrash()
+
+			cfg := config{dupes: true, dupesAction: tt.action, wLog: &logBuffer}
+			if tt.action == "archive" {
+				cfg.archive = archiveDir
+			}
+			if tt.trash {
+				cfg.trash = trashDir
+			}
+			if tt.confirm != "" {
+				cfg.confirm = tt.confirm
+				cfg.in = strings.NewReader(tt.input)
+			}
+
+			err := run(tempDir, &buffer, cfg)
+			if tt.expErr {
+				if err == nil {
+					t.Fatal("Expected error, got nil instead")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expSummary := "1 duplicate sets, 2 redundant files, 18 bytes\n"
+			if !strings.HasSuffix(buffer.String(), expSummary) {
+				t.Errorf("Expected summary %q, got %q instead\n", expSummary, buffer.String())
+			}
+
+			if !strings.Contains(buffer.String(), "3 copies, 9 bytes each:\n") {
+				t.Errorf("Expected duplicate set in output, got %q instead\n", buffer.String())
+			}
+
+			var left []string
+			err = filepath.Walk(tempDir, func(path string, info os.FileInfo, err error) error {
+				if err == nil && !info.IsDir() {
+					left = append(left, path)
+				}
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(left) != tt.nLeft {
+				t.Errorf("Expected %d files left, got %v instead\n", tt.nLeft, left)
+			}
+
+			switch tt.action {
+			case "hardlink":
+				a, err := os.Stat(filepath.Join(tempDir, "a.log"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				d, err := os.Stat(filepath.Join(tempDir, "sub", "d.log"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !os.SameFile(a, d) {
+					t.Error("Expected sub/d.log to be a hard link to a.log")
+				}
+			case "delete", "archive":
+				if n := strings.Count(logBuffer.String(), "DELETED FILE:"); n != 4-tt.nLeft {
+					t.Errorf("Expected %d log lines, got %d instead\n", 4-tt.nLeft, n)
+				}
+			}
+
+			batches, err := listBatches(trashDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			nTrash := 0
+			for _, b := range batches {
+				nTrash += len(b.manifest.Files)
+			}
+			if nTrash != tt.nTrash {
+				t.Errorf("Expected %d files in the trash, got %d instead\n", tt.nTrash, nTrash)
+			}
+
+			if tt.action == "archive" {
+				if _, err := os.Stat(filepath.Join(archiveDir, "sub", "d.log.gz")); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
+
+func TestRunDu(t *testing.T) {
+	var buffer bytes.Buffer
+
+	tempDir, cleanup := createTempDir(t, nil)
+	defer cleanup()
+
+	files := map[string]int{
+		"a.log":          100,
+		"b.txt":          50,
+		"sub/c.log":      300,
+		"sub/deep/d.log": 200,
+		"other/e.log":    10,
+	}
+	for name, size := range files {
+		p := filepath.Join(tempDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, bytes.Repeat([]byte("x"), size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := config{ext: ".log", du: true, depth: 1, top: 2, duFormat: "json"}
+	if err := run(tempDir, &buffer, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	var r duReport
+	if err := json.Unmarshal(buffer.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+
+	expDirs := []duEntry{
+		{Path: tempDir, Bytes: 610, Files: 4},
+		{Path: filepath.Join(tempDir, "sub"), Bytes: 500, Files: 2},
+	}
+	expFiles := []duEntry{
+		{Path: filepath.Join(tempDir, "sub", "c.log"), Bytes: 300},
+		{Path: filepath.Join(tempDir, "sub", "deep", "d.log"), Bytes: 200},
+	}
+
+	if len(r.Dirs) != len(expDirs) || len(r.Files) != len(expFiles) {
+		t.Fatalf("Expected %d dirs and %d files, got %+v instead\n", len(expDirs), len(expFiles), r)
+	}
+
+	for i, d := range expDirs {
+		if r.Dirs[i] != d {
+			t.Errorf("Expected dir %+v, got %+v instead\n", d, r.Dirs[i])
+		}
+	}
+
+	for i, f := range expFiles {
+		if r.Files[i] != f {
+			t.Errorf("Expected file %+v, got %+v instead\n", f, r.Files[i])
+		}
+	}
+
+	buffer.Reset()
+	cfg.duFormat = "table"
+	if err := run(tempDir, &buffer, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(buffer.String(), "DIRECTORY") || !strings.Contains(buffer.String(), "500 B") {
+		t.Errorf("Unexpected table output %q\n", buffer.String())
+	}
+}
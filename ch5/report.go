@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+var actions = []string{"listed", "archived", "deleted", "linked", "skipped", "errored"}
+
+type event struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	Path   string    `json:"path"`
+	Size   int64     `json:"size"`
+	Source string    `json:"source,omitempty"`
+	Target string    `json:"target,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// recorder counts every action taken during a run and, when a JSON log
+// is configured, writes one JSON line per action.
+type recorder struct {
+	enc   *json.Encoder
+	start time.Time
+	files map[string]int
+	bytes map[string]int64
+
+	keepGoing bool
+	failures  []fileErr
+}
+
+func newRecorder(jsonLog io.Writer) *recorder {
+	r := &recorder{
+		start: time.Now(),
+		files: make(map[string]int),
+		bytes: make(map[string]int64),
+	}
+
+	if jsonLog != nil {
+		r.enc = json.NewEncoder(jsonLog)
+	}
+
+	return r
+}
+
+func (r *recorder) record(e event) error {
+	r.files[e.Action]++
+	r.bytes[e.Action] += e.Size
+
+	if r.enc == nil {
+		return nil
+	}
+
+	e.Time = time.Now()
+	return r.enc.Encode(e)
+}
+
+// fail records an errored action. It returns err so the walk stops, or
+// nil when the recorder keeps going after errors.
+func (r *recorder) fail(path string, info os.FileInfo, err error) error {
+	var size int64
+	if info != nil {
+		size = info.Size()
+	}
+
+	if rerr := r.record(event{Action: "errored", Path: path, Size: size, Error: err.Error()}); rerr != nil {
+		return rerr
+	}
+
+	if !r.keepGoing {
+		return err
+	}
+
+	r.failures = append(r.failures, fileErr{path: path, cause: err})
+	return nil
+}
+
+type actionSummary struct {
+	Action string `json:"action"`
+	Files  int    `json:"files"`
+	Bytes  int64  `json:"bytes"`
+}
+
+type summary struct {
+	Actions        []actionSummary `json:"actions"`
+	BytesReclaimed int64           `json:"bytes_reclaimed"`
+	Elapsed        string          `json:"elapsed"`
+}
+
+func (r *recorder) summary() summary {
+	s := summary{
+		BytesReclaimed: r.bytes["deleted"] + r.bytes["linked"],
+		Elapsed:        time.Since(r.start).Round(time.Millisecond).String(),
+	}
+
+	for _, a := range actions {
+		s.Actions = append(s.Actions, actionSummary{Action: a, Files: r.files[a], Bytes: r.bytes[a]})
+	}
+
+	return s
+}
+
+// printSummary writes the run summary as a "table" or as "json".
+func printSummary(out io.Writer, s summary, format string) error {
+	switch format {
+	case "json":
+		return json.NewEncoder(out).Encode(s)
+	case "table":
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ACTION\tFILES\tBYTES")
+		for _, a := range s.Actions {
+			fmt.Fprintf(tw, "%s\t%d\t%d\n", a.Action, a.Files, a.Bytes)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+
+		_, err := fmt.Fprintf(out, "Bytes reclaimed: %d\nElapsed: %s\n", s.BytesReclaimed, s.Elapsed)
+		return err
+	}
+
+	return fmt.Errorf("invalid summary format %q: use table or json", format)
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// restoreArchive walks archiveDir and decompresses every .gz or .zst file
+// back to its relative path under root. The files of tar and zip bundles
+// are restored to the paths stored in the bundle, relative to root.
+func restoreArchive(archiveDir, root string, out io.Writer, cfg config) error {
+	if err := checkConflict(cfg.conflict); err != nil {
+		return err
+	}
+
+	return filepath.Walk(archiveDir,
+		func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if info.IsDir() {
+				return nil
+			}
+
+			if isBundle(path) {
+				return restoreBundle(path, root, out, cfg)
+			}
+
+			ext := filepath.Ext(path)
+			if ext != ".gz" && ext != ".zst" {
+				return nil
+			}
+
+			relDir, err := filepath.Rel(archiveDir, filepath.Dir(path))
+			if err != nil {
+				return err
+			}
+
+			return restoreFile(path, filepath.Join(root, relDir), out, cfg)
+		})
+}
+
+func restoreFile(path, destDir string, out io.Writer, cfg config) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	var (
+		r       io.Reader
+		modTime time.Time
+	)
+
+	if filepath.Ext(path) == ".gz" {
+		zr, err := gzip.NewReader(in)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		defer zr.Close()
+
+		if zr.Name != "" {
+			name = filepath.Base(zr.Name)
+		}
+		modTime = zr.ModTime
+		r = zr
+	} else {
+		zr, err := zstd.NewReader(in)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	return restoreEntry(path, r, filepath.Join(destDir, name), modTime, out, cfg)
+}
+
+// isBundle reports whether path is a tar or zip bundle, by the name
+// bundleName gives it. Per-file archives of tar files also end in .tar.gz
+// or .tar.zst, but are restored as the tar file itself.
+func isBundle(path string) bool {
+	name := filepath.Base(path)
+	if !strings.HasPrefix(name, "archive-") {
+		return false
+	}
+
+	for _, format := range bundleFormats {
+		stamp := strings.TrimPrefix(name, "archive-")
+		if !strings.HasSuffix(stamp, "."+format) {
+			continue
+		}
+
+		if _, err := time.Parse(bundleTime, strings.TrimSuffix(stamp, "."+format)); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// restoreBundle restores every regular file of the bundle at path to its
+// stored relative path under root.
+func restoreBundle(path, root string, out io.Writer, cfg config) error {
+	if strings.HasSuffix(path, ".zip") {
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		defer zr.Close()
+
+		for _, f := range zr.File {
+			if !f.Mode().IsRegular() {
+				continue
+			}
+
+			if err := restoreZipEntry(path, f, root, out, cfg); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	var r io.Reader
+	if strings.HasSuffix(path, ".tar.gz") {
+		zr, err := gzip.NewReader(in)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		defer zr.Close()
+		r = zr
+	} else {
+		zr, err := zstd.NewReader(in)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		target, err := bundleTarget(root, path, hdr.Name)
+		if err != nil {
+			return err
+		}
+
+		if err := restoreEntry(path+":"+hdr.Name, tr, target, hdr.ModTime, out, cfg); err != nil {
+			return err
+		}
+	}
+}
+
+func restoreZipEntry(path string, f *zip.File, root string, out io.Writer, cfg config) error {
+	target, err := bundleTarget(root, path, f.Name)
+	if err != nil {
+		return err
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	defer rc.Close()
+
+	return restoreEntry(path+":"+f.Name, rc, target, f.Modified, out, cfg)
+}
+
+// bundleTarget returns the path under root for a file stored in a bundle
+// as name, refusing names that would end up outside of root.
+func bundleTarget(root, bundle, name string) (string, error) {
+	rel := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s: invalid path %q in bundle", bundle, name)
+	}
+
+	return filepath.Join(root, rel), nil
+}
+
+// restoreEntry writes the content of r to target, applying the conflict
+// policy. src names the archived copy in messages.
+func restoreEntry(src string, r io.Reader, target string, modTime time.Time, out io.Writer, cfg config) error {
+	if _, err := os.Stat(target); err == nil {
+		switch cfg.conflict {
+		case "overwrite":
+		case "rename":
+			target = freePath(target)
+		default:
+			_, err := fmt.Fprintf(out, "skipped: %s exists\n", target)
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if cfg.dryRun {
+		_, err := fmt.Fprintf(out, "would restore: %s -> %s\n", src, target)
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(target, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return fmt.Errorf("%s: %w", src, err)
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if !modTime.IsZero() {
+		if err := os.Chtimes(target, modTime, modTime); err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintf(out, "restored: %s -> %s\n", src, target)
+	return err
+}
+
+// checkConflict validates a restore policy for existing files.
+func checkConflict(policy string) error {
+	switch policy {
+	case "", "skip", "overwrite", "rename":
+		return nil
+	}
+
+	return fmt.Errorf("invalid conflict policy %q: use skip, overwrite or rename", policy)
+}
+
+// freePath returns the first path of the form name_N.ext that does not
+// exist yet.
+func freePath(path string) string {
+	ext := filepath.Ext(path)
+	stem := strings.TrimSuffix(path, ext)
+
+	for i := 1; ; i++ {
+		p := fmt.Sprintf("%s_%d%s", stem, i, ext)
+		if _, err := os.Stat(p); os.IsNotExist(err) {
+			return p
+		}
+	}
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+)
+
+const (
+	manifestName = "manifest.json"
+	journalName  = "files.jsonl"
+)
+
+type trashEntry struct {
+	Original string `json:"original"`
+	Stored   string `json:"stored"`
+	Size     int64  `json:"size"`
+}
+
+type trashManifest struct {
+	Created time.Time    `json:"created"`
+	Root    string       `json:"root"`
+	Files   []trashEntry `json:"files"`
+}
+
+// trashBatch moves files into a timestamped directory under the trash
+// directory instead of deleting them. The batch directory and its manifest
+// are created on the first call to add. Every file moved is appended to a
+// journal right away, so a batch cut short by a crash can still be listed,
+// restored and expired. Close folds the journal into the manifest.
+type trashBatch struct {
+	trashDir string
+	dir      string
+	manifest trashManifest
+	journal  *os.File
+}
+
+func newTrashBatch(trashDir string) *trashBatch {
+	return &trashBatch{trashDir: trashDir}
+}
+
+func (b *trashBatch) open(root string) error {
+	info, err := os.Stat(b.trashDir)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", b.trashDir)
+	}
+
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	b.dir = filepath.Join(b.trashDir, now.Format("20060102-150405.000000"))
+	if err := os.Mkdir(b.dir, 0755); err != nil {
+		return err
+	}
+
+	b.manifest = trashManifest{Created: now, Root: absRoot}
+	if err := writeManifest(b.dir, b.manifest); err != nil {
+		return err
+	}
+
+	b.journal, err = os.OpenFile(filepath.Join(b.dir, journalName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	return err
+}
+
+func (b *trashBatch) add(root, path string) error {
+	if b.dir == "" {
+		if err := b.open(root); err != nil {
+			return err
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	original, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return err
+	}
+
+	if err := moveFile(path, filepath.Join(b.dir, rel)); err != nil {
+		return err
+	}
+
+	entry := trashEntry{
+		Original: original,
+		Stored:   filepath.ToSlash(rel),
+		Size:     info.Size(),
+	}
+	b.manifest.Files = append(b.manifest.Files, entry)
+
+	return json.NewEncoder(b.journal).Encode(entry)
+}
+
+func (b *trashBatch) target(root, path string) (string, error) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(b.dir, rel), nil
+}
+
+func (b *trashBatch) Close() error {
+	if b.dir == "" {
+		return nil
+	}
+
+	if err := b.journal.Close(); err != nil {
+		return err
+	}
+
+	return saveManifest(b.dir, b.manifest)
+}
+
+// writeManifest replaces the manifest of dir. It writes a temporary file
+// first so the manifest is never left half written.
+func writeManifest(dir string, m trashManifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(dir, manifestName+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filepath.Join(dir, manifestName))
+}
+
+// saveManifest writes the complete manifest of dir and drops the journal,
+// whose entries it now holds.
+func saveManifest(dir string, m trashManifest) error {
+	if err := writeManifest(dir, m); err != nil {
+		return err
+	}
+
+	err := os.Remove(filepath.Join(dir, journalName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// readManifest reads the manifest of dir, adding the files of a journal
+// left behind by a batch that was never closed.
+func readManifest(dir string) (trashManifest, error) {
+	var m trashManifest
+
+	data, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if err != nil {
+		return m, err
+	}
+
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("%s: %w", dir, err)
+	}
+
+	journal, err := os.Open(filepath.Join(dir, journalName))
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return m, err
+	}
+	defer journal.Close()
+
+	// The journal may repeat files of the manifest when the run stopped
+	// right after writing it.
+	seen := make(map[string]bool, len(m.Files))
+	for _, f := range m.Files {
+		seen[f.Stored] = true
+	}
+
+	dec := json.NewDecoder(journal)
+	for {
+		// Stop at the end, or at a last line cut short by a crash.
+		var f trashEntry
+		if err := dec.Decode(&f); err != nil {
+			break
+		}
+
+		if !seen[f.Stored] {
+			seen[f.Stored] = true
+			m.Files = append(m.Files, f)
+		}
+	}
+
+	return m, nil
+}
+
+// moveFile renames src to dst, falling back to copy and delete when they
+// are on different devices.
+func moveFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	err := os.Rename(src, dst)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_RDWR|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chtimes(dst, info.ModTime(), info.ModTime()); err != nil {
+		return err
+	}
+
+	return os.Remove(src)
+}
+
+type trashBatchInfo struct {
+	id       string
+	manifest trashManifest
+}
+
+func listBatches(trashDir string) ([]trashBatchInfo, error) {
+	entries, err := os.ReadDir(trashDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var batches []trashBatchInfo
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+
+		m, err := readManifest(filepath.Join(trashDir, e.Name()))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		batches = append(batches, trashBatchInfo{id: e.Name(), manifest: m})
+	}
+
+	sort.Slice(batches, func(i, j int) bool {
+		return batches[i].manifest.Created.Before(batches[j].manifest.Created)
+	})
+
+	return batches, nil
+}
+
+func trashList(trashDir string, out io.Writer) error {
+	batches, err := listBatches(trashDir)
+	if err != nil {
+		return err
+	}
+
+	for _, b := range batches {
+		var size int64
+		for _, f := range b.manifest.Files {
+			size += f.Size
+		}
+
+		if _, err := fmt.Fprintf(out, "%s\t%s\t%d files\t%d bytes\t%s\n", b.id,
+			b.manifest.Created.Format(time.RFC3339), len(b.manifest.Files), size, b.manifest.Root); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// trashRestore moves every file of a batch back to its original path.
+// Files skipped because of the conflict policy stay in the batch. A dry
+// run only prints what would be restored.
+func trashRestore(trashDir, id string, out io.Writer, cfg config) error {
+	if err := checkConflict(cfg.conflict); err != nil {
+		return err
+	}
+
+	dir := filepath.Join(trashDir, filepath.Base(id))
+
+	m, err := readManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	var remaining []trashEntry
+	for i, f := range m.Files {
+		restored, err := restoreTrashed(dir, f, out, cfg)
+		if err != nil {
+			if cfg.dryRun {
+				return err
+			}
+
+			// Keep every file not restored yet, so the batch can still
+			// be restored once the cause is fixed.
+			left := m.Files[i:]
+			if restored {
+				left = m.Files[i+1:]
+			}
+			m.Files = append(remaining, left...)
+
+			if serr := saveManifest(dir, m); serr != nil {
+				return fmt.Errorf("%w, and saving the manifest failed: %v", err, serr)
+			}
+			return err
+		}
+
+		if !restored {
+			remaining = append(remaining, f)
+		}
+	}
+
+	if cfg.dryRun {
+		return nil
+	}
+
+	if len(remaining) > 0 {
+		m.Files = remaining
+		return saveManifest(dir, m)
+	}
+
+	return os.RemoveAll(dir)
+}
+
+// restoreTrashed moves one file of the batch in dir back to its original
+// path and reports whether it was moved, even when printing that fails.
+func restoreTrashed(dir string, f trashEntry, out io.Writer, cfg config) (bool, error) {
+	target := f.Original
+
+	if _, err := os.Stat(target); err == nil {
+		switch cfg.conflict {
+		case "overwrite":
+		case "rename":
+			target = freePath(target)
+		default:
+			_, err := fmt.Fprintf(out, "skipped: %s exists\n", target)
+			return false, err
+		}
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+
+	stored := filepath.Join(dir, filepath.FromSlash(f.Stored))
+
+	if cfg.dryRun {
+		_, err := fmt.Fprintf(out, "would restore: %s -> %s\n", stored, target)
+		return false, err
+	}
+
+	if err := moveFile(stored, target); err != nil {
+		return false, err
+	}
+
+	_, err := fmt.Fprintf(out, "restored: %s\n", target)
+	return true, err
+}
+
+// trashExpire permanently removes batches older than retention. A dry run
+// only prints what would be expired.
+func trashExpire(trashDir string, retention time.Duration, now time.Time, out io.Writer, dryRun bool) error {
+	batches, err := listBatches(trashDir)
+	if err != nil {
+		return err
+	}
+
+	for _, b := range batches {
+		if now.Sub(b.manifest.Created) < retention {
+			continue
+		}
+
+		if dryRun {
+			if _, err := fmt.Fprintf(out, "would expire: %s (%d files)\n", b.id, len(b.manifest.Files)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := os.RemoveAll(filepath.Join(trashDir, b.id)); err != nil {
+			return err
+		}
+
+		if _, err := fmt.Fprintf(out, "expired: %s (%d files)\n", b.id, len(b.manifest.Files)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// killGrace is how long a command has to exit after SIGTERM before its
+// process group is killed.
+var killGrace = 5 * time.Second
+
+// runCommand runs cmd in its own process group. When ctx is done, it sends
+// SIGTERM to the whole group, so the command and everything it started can
+// clean up, and SIGKILL if they are still running after killGrace.
+//
+// The group is not the terminal's foreground process group, so a command
+// that reads from the terminal is stopped by SIGTTIN and hangs until ctx
+// is done. Commands must not prompt, like git with GIT_TERMINAL_PROMPT=0.
+func runCommand(ctx context.Context, cmd *exec.Cmd) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	waitCh := make(chan error, 1)
+	go func() {
+		waitCh <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-waitCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	// With Setpgid the group ID is the command's PID.
+	pgid := cmd.Process.Pid
+	syscall.Kill(-pgid, syscall.SIGTERM)
+
+	select {
+	case err := <-waitCh:
+		return err
+	case <-time.After(killGrace):
+		syscall.Kill(-pgid, syscall.SIGKILL)
+		return <-waitCh
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestRunCommandCancel(t *testing.T) {
+	defer func(grace time.Duration) { killGrace = grace }(killGrace)
+	killGrace = 500 * time.Millisecond
+
+	var tests = []struct {
+		name   string
+		script string
+		out    string
+	}{
+		// Wait only returns once the background sleep, which holds the
+		// output pipe, is gone too.
+		{name: "Group", script: "sleep 30 & echo started; wait"},
+		{name: "Terminate", script: "trap 'echo cleanup; exit 1' TERM; sleep 30 & echo started; wait", out: "cleanup\n"},
+		{name: "Kill", script: "trap '' TERM; sleep 30 & echo started; wait"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var out bytes.Buffer
+			started := make(chan struct{})
+			cmd := exec.Command("sh", "-c", tt.script)
+			cmd.Stdout = &lineNotifier{out: &out, line: "started\n", ch: started}
+
+			errCh := make(chan error)
+			go func() {
+				errCh <- runCommand(ctx, cmd)
+			}()
+
+			select {
+			case <-started:
+			case <-time.After(5 * time.Second):
+				t.Fatal("command did not start")
+			}
+			cancel()
+
+			select {
+			case err := <-errCh:
+				if err == nil {
+					t.Errorf("Expected error, got 'nil' instead")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("command still running after cancel")
+			}
+
+			if exp := "started\n" + tt.out; out.String() != exp {
+				t.Errorf("Expected output %q, got %q instead", exp, out.String())
+			}
+		})
+	}
+}
+
+func TestRunCommandDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := runCommand(ctx, exec.Command("true")); err != context.Canceled {
+		t.Errorf("Expected error %q, got %q instead", context.Canceled, err)
+	}
+}
+
+// lineNotifier writes to out and closes ch once line has been written.
+type lineNotifier struct {
+	out  *bytes.Buffer
+	line string
+	ch   chan struct{}
+}
+
+func (w *lineNotifier) Write(p []byte) (int, error) {
+	n, err := w.out.Write(p)
+	if w.ch != nil && bytes.Contains(w.out.Bytes(), []byte(w.line)) {
+		close(w.ch)
+		w.ch = nil
+	}
+
+	return n, err
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// runStages runs each stage as soon as the stages it needs have
+// succeeded, so independent stages run concurrently. Stages downstream of
+// a failed stage do not run, while independent ones carry on. Step output
+// goes to stepOut and success messages to out.
+func runStages(ctx context.Context, pipeline []stage, out, stepOut io.Writer) error {
+	index := make(map[string]int, len(pipeline))
+	done := make([]chan struct{}, len(pipeline))
+	for i, s := range pipeline {
+		index[s.name] = i
+		done[i] = make(chan struct{})
+	}
+
+	// ok and errs are written by the stage's own goroutine before it
+	// closes done, and read by others only after done is closed.
+	ok := make([]bool, len(pipeline))
+	errs := make([]error, len(pipeline))
+
+	var wg sync.WaitGroup
+	for i := range pipeline {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer close(done[i])
+
+			for _, n := range pipeline[i].needs {
+				j := index[n]
+				select {
+				case <-done[j]:
+				case <-ctx.Done():
+					return
+				}
+
+				if !ok[j] {
+					return
+				}
+			}
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			msg, err := pipeline[i].execute(ctx, stepOut)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+
+			if _, err := fmt.Fprintln(out, msg); err != nil {
+				errs[i] = err
+				return
+			}
+
+			ok[i] = true
+		}(i)
+	}
+	wg.Wait()
+
+	var failed pipelineErr
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+
+	switch len(failed) {
+	case 0:
+		return nil
+	case 1:
+		return failed[0]
+	}
+
+	return failed
+}
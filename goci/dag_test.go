@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStep writes its name to the step output and then runs fn.
+type fakeStep struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+func (s fakeStep) execute(ctx context.Context, out io.Writer) (string, error) {
+	fmt.Fprintln(out, s.name)
+
+	if s.fn != nil {
+		if err := s.fn(ctx); err != nil {
+			return "", &stepErr{step: s.name, msg: "failed to execute", cause: err}
+		}
+	}
+
+	return s.name + ": SUCCESS", nil
+}
+
+func fakeStage(name string, fn func(ctx context.Context) error, needs ...string) stage {
+	return stage{name: name, needs: needs, executer: fakeStep{name: name, fn: fn}}
+}
+
+func fail(ctx context.Context) error {
+	return errors.New("exit status 1")
+}
+
+// barrier returns a step function that only succeeds when n steps call it
+// at the same time.
+func barrier(n int) func(ctx context.Context) error {
+	var wg sync.WaitGroup
+	wg.Add(n)
+
+	return func(ctx context.Context) error {
+		wg.Done()
+
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+			return nil
+		case <-time.After(5 * time.Second):
+			return errors.New("steps did not run concurrently")
+		}
+	}
+}
+
+func TestRunStages(t *testing.T) {
+	parallel := barrier(3)
+
+	var tests = []struct {
+		name     string
+		pipeline []stage
+		expRan   string
+		expOut   string
+		expErr   []string
+	}{
+		{name: "Parallel", pipeline: []stage{
+			fakeStage("vet", parallel),
+			fakeStage("test", parallel),
+			fakeStage("fmt", parallel),
+			fakeStage("push", nil, "vet", "test", "fmt"),
+		}, expRan: "fmt push test vet", expOut: "push: SUCCESS"},
+		{name: "FailDownstream", pipeline: []stage{
+			fakeStage("build", fail),
+			fakeStage("test", nil, "build"),
+			fakeStage("push", nil, "test"),
+			fakeStage("fmt", nil),
+		}, expRan: "build fmt", expOut: "fmt: SUCCESS", expErr: []string{"build"}},
+		{name: "FailIndependent", pipeline: []stage{
+			fakeStage("vet", fail),
+			fakeStage("fmt", fail),
+			fakeStage("push", nil, "fmt"),
+		}, expRan: "fmt vet", expErr: []string{"vet", "fmt"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out, stepOut bytes.Buffer
+			err := runStages(context.Background(), tt.pipeline, &syncWriter{out: &out}, &syncWriter{out: &stepOut})
+
+			if tt.expErr == nil && err != nil {
+				t.Fatalf("Unexpected error: %q", err)
+			}
+
+			for _, name := range tt.expErr {
+				if !errors.Is(err, &stepErr{step: name}) {
+					t.Errorf("Expected error %q, got %q instead", &stepErr{step: name}, err)
+				}
+			}
+
+			ran := strings.Fields(stepOut.String())
+			sort.Strings(ran)
+			if strings.Join(ran, " ") != tt.expRan {
+				t.Errorf("Expected steps %q to run, got %q instead", tt.expRan, ran)
+			}
+
+			// Only the last message has a fixed position, the others
+			// come from steps running concurrently.
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			if last := lines[len(lines)-1]; last != tt.expOut {
+				t.Errorf("Expected last message %q, got %q instead", tt.expOut, last)
+			}
+		})
+	}
+}
+
+func TestRunStagesCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+
+	pipeline := []stage{
+		fakeStage("build", func(ctx context.Context) error {
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		}),
+		fakeStage("test", nil, "build"),
+	}
+
+	var stepOut bytes.Buffer
+	errCh := make(chan error)
+	go func() {
+		errCh <- runStages(ctx, pipeline, io.Discard, &syncWriter{out: &stepOut})
+	}()
+
+	<-started
+	cancel()
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected error %q, got %q instead", context.Canceled, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("runStages did not return after cancel")
+	}
+
+	if stepOut.String() != "build\n" {
+		t.Errorf("Expected only build to run, got %q instead", stepOut.String())
+	}
+}
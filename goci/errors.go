@@ -1,23 +1,34 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 )
 
 var (
-	ErrValidation = errors.New("validation failed")
-	ErrSignal     = errors.New("received signal")
+	ErrValidation  = errors.New("validation failed")
+	ErrSignal      = errors.New("received signal")
+	ErrUncommitted = errors.New("uncommitted changes")
 )
 
 type stepErr struct {
-	step  string
-	msg   string
-	cause error
+	step   string
+	msg    string
+	cause  error
+	output string
 }
 
+// Error includes the last lines of the step's output, if any.
 func (s *stepErr) Error() string {
-	return fmt.Sprintf("Step: %q: %s: Cause: %v", s.step, s.msg, s.cause)
+	msg := fmt.Sprintf("Step: %q: %s: Cause: %v", s.step, s.msg, s.cause)
+	if s.output == "" {
+		return msg
+	}
+
+	return fmt.Sprintf("%s\nOutput:\n%s", msg, s.output)
 }
 
 func (s *stepErr) Is(target error) bool {
@@ -32,3 +43,45 @@ func (s *stepErr) Is(target error) bool {
 func (s *stepErr) Unwrap() error {
 	return s.cause
 }
+
+// pipelineErr holds the errors of steps that failed independently of
+// each other, in pipeline order.
+type pipelineErr []error
+
+func (p pipelineErr) Error() string {
+	msgs := make([]string, len(p))
+	for i, err := range p {
+		msgs[i] = err.Error()
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
+func (p pipelineErr) Is(target error) bool {
+	for _, err := range p {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// interrupted returns the quoted names of the steps err reports as
+// interrupted by a canceled context.
+func interrupted(err error) []string {
+	errs, ok := err.(pipelineErr)
+	if !ok && err != nil {
+		errs = pipelineErr{err}
+	}
+
+	var steps []string
+	for _, err := range errs {
+		var se *stepErr
+		if errors.As(err, &se) && se.cause == context.Canceled {
+			steps = append(steps, strconv.Quote(se.step))
+		}
+	}
+
+	return steps
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"os/exec"
 )
 
@@ -18,20 +20,22 @@ func newExceptionStep(name, exe, message, proj string, args []string) exceptionS
 	return s
 }
 
-func (s exceptionStep) execute() (string, error) {
+func (s exceptionStep) execute(ctx context.Context, stream io.Writer) (string, error) {
 	cmd := exec.Command(s.exe, s.args...)
 
 	var out bytes.Buffer
 
-	cmd.Stdout = &out
+	output := newStepOutput(stream, s.name)
+	cmd.Stdout = io.MultiWriter(output, &out)
+	cmd.Stderr = output
 	cmd.Dir = s.proj
+	s.setEnv(cmd)
 
-	if err := cmd.Run(); err != nil {
-		return "", &stepErr{
-			step:  s.name,
-			msg:   "failed to execute",
-			cause: err,
-		}
+	err := runCommand(ctx, cmd)
+	tail := output.close()
+
+	if err != nil {
+		return "", s.failure(ctx, "failed to execute", err, tail)
 	}
 
 	if out.Len() > 0 {
@@ -1,102 +1,98 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
-	"time"
 )
 
+// executer runs a pipeline step, writing the output of its command to
+// out, and returns the success message. Canceling ctx terminates the
+// command along with the processes it started.
 type executer interface {
-	execute() (string, error)
+	execute(ctx context.Context, out io.Writer) (string, error)
+}
+
+type config struct {
+	proj     string
+	pipeline string
+	quiet    bool
+	push     pushOptions
 }
 
 func main() {
 	proj := flag.String("p", "", "Project directory")
+	pipeline := flag.String("f", "", "Pipeline file in YAML or JSON (default .goci.yaml, .goci.yml or .goci.json in the project directory)")
+	quiet := flag.Bool("q", false, "Quiet: do not stream step output, show it only when a step fails")
+	remote := flag.String("remote", "", "Git remote to push to (default the branch's upstream remote, or origin)")
+	branch := flag.String("branch", "", "Git branch to push (default the current branch, to its upstream branch)")
+	skipPush := flag.Bool("skip-push", false, "Skip the git push steps")
 	flag.Parse()
 
-	if err := run(*proj, os.Stdout); err != nil {
+	c := config{
+		proj:     *proj,
+		pipeline: *pipeline,
+		quiet:    *quiet,
+		push: pushOptions{
+			remote: *remote,
+			branch: *branch,
+			skip:   *skipPush,
+		},
+	}
+
+	if err := run(c, os.Stdout); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
 
-func run(proj string, out io.Writer) error {
-	if proj == "" {
+func run(cfg config, out io.Writer) error {
+	if cfg.proj == "" {
 		return fmt.Errorf("project directory is required: %w", ErrValidation)
 	}
 
-	pipeline := make([]executer, 4)
-
-	pipeline[0] = newStep(
-		"go build",
-		"go",
-		"Go Build: SUCCESS",
-		proj,
-		[]string{"build", ".", "errors"},
-	)
-
-	pipeline[1] = newStep(
-		"go test",
-		"go",
-		"Go Test: SUCCESS",
-		proj,
-		[]string{"test", "-v"},
-	)
-
-	pipeline[2] = newExceptionStep(
-		"go fmt",
-		"gofmt",
-		"Gofmt: SUCCESS",
-		proj,
-		[]string{"-l", "."},
-	)
+	pipeline, err := loadPipeline(cfg.proj, cfg.pipeline, cfg.push)
+	if err != nil {
+		return err
+	}
 
-	pipeline[3] = newTimeoutStep(
-		"git push",
-		"git",
-		"Git Push: SUCCESS",
-		proj,
-		[]string{"push", "origin", "master"},
-		10*time.Second,
-	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	sig := make(chan os.Signal, 1)
-	errCh := make(chan error)
-	done := make(chan struct{})
+	errCh := make(chan error, 1)
 
 	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	// Steps may run concurrently, so all writes to out go through one
+	// lock. In quiet mode step output only shows in the error of a
+	// failed step.
+	out = &syncWriter{out: out}
+	stepOut := out
+	if cfg.quiet {
+		stepOut = io.Discard
+	}
 
 	go func() {
-		for _, s := range pipeline {
-			msg, err := s.execute()
-			if err != nil {
-				errCh <- err
-				return
-			}
-
-			_, err = fmt.Fprintln(out, msg)
-			if err != nil {
-				errCh <- err
-				return
-			}
-		}
-		close(done)
+		errCh <- runStages(ctx, pipeline, out, stepOut)
 	}()
 
-	for {
-		select {
-		case rec := <-sig:
-			signal.Stop(sig)
-			return fmt.Errorf("%s: Exiting: %w", rec, ErrSignal)
-		case err := <-errCh:
-			return err
-		case <-done:
-			return nil
+	select {
+	case rec := <-sig:
+		signal.Stop(sig)
+		// Stop the running commands and wait for them before exiting.
+		cancel()
+		if steps := interrupted(<-errCh); len(steps) > 0 {
+			return fmt.Errorf("%s: Exiting: interrupted %s: %w", rec, strings.Join(steps, ", "), ErrSignal)
 		}
+		return fmt.Errorf("%s: Exiting: %w", rec, ErrSignal)
+	case err := <-errCh:
+		return err
 	}
-
 }
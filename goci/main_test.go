@@ -10,6 +10,7 @@ import (
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"sync"
 	"syscall"
 	"testing"
 	"time"
@@ -26,10 +27,12 @@ func TestRun(t *testing.T) {
 		out      string
 		expErr   error
 		setupGit bool
-		mockCmd  func(ctx context.Context, name string, arg ...string) *exec.Cmd
+		push     pushOptions
+		mockCmd  func(name string, arg ...string) *exec.Cmd
 	}{
 		{name: "success", proj: "./testdata/tool/", out: "Go Build: SUCCESS\nGo Test: SUCCESS\nGofmt: SUCCESS\nGit Push: SUCCESS\n", expErr: nil, setupGit: true, mockCmd: nil},
-		{name: "successMock", proj: "./testdata/tool/", out: "Go Build: SUCCESS\nGo Test: SUCCESS\nGofmt: SUCCESS\nGit Push: SUCCESS\n", expErr: nil, setupGit: false, mockCmd: mockCmdContext},
+		{name: "successMock", proj: "./testdata/tool/", out: "Go Build: SUCCESS\nGo Test: SUCCESS\nGofmt: SUCCESS\nGit Push: SUCCESS\n", expErr: nil, setupGit: false, mockCmd: mockCmd},
+		{name: "successSkipPush", proj: "./testdata/tool/", out: "Go Build: SUCCESS\nGo Test: SUCCESS\nGofmt: SUCCESS\ngit push: SKIPPED\n", expErr: nil, setupGit: false, push: pushOptions{skip: true}, mockCmd: nil},
 		{name: "fail", proj: "./testdata/toolErr/", out: "", expErr: &stepErr{step: "go build"}, setupGit: false, mockCmd: nil},
 		{name: "failFormat", proj: "./testdata/toolFmtErr", out: "", expErr: &stepErr{step: "go fmt"}, setupGit: false},
 		{name: "failTimeout", proj: "./testdata/tool", out: "", expErr: context.DeadlineExceeded, setupGit: false, mockCmd: mockCmdTimeout},
@@ -52,7 +55,7 @@ func TestRun(t *testing.T) {
 			}
 
 			var out bytes.Buffer
-			err := run(tt.proj, &out)
+			err := run(config{proj: tt.proj, quiet: true, push: tt.push}, &out)
 
 			if tt.expErr != nil {
 				if err == nil {
@@ -127,18 +130,18 @@ func setupGit(t *testing.T, proj string) func() {
 	}
 }
 
-func mockCmdContext(ctx context.Context, exe string, args ...string) *exec.Cmd {
+func mockCmd(exe string, args ...string) *exec.Cmd {
 	cs := []string{"-test.run=TestHelperProcess"}
 	cs = append(cs, exe)
 	cs = append(cs, args...)
 
-	cmd := exec.CommandContext(ctx, os.Args[0], cs...)
+	cmd := exec.Command(os.Args[0], cs...)
 	cmd.Env = []string{"GO_WANT_HELPER_PROCESS=1"}
 	return cmd
 }
 
-func mockCmdTimeout(ctx context.Context, exe string, args ...string) *exec.Cmd {
-	cmd := mockCmdContext(ctx, exe, args...)
+func mockCmdTimeout(exe string, args ...string) *exec.Cmd {
+	cmd := mockCmd(exe, args...)
 	cmd.Env = append(cmd.Env, "GO_HELPER_TIMEOUT=1")
 	return cmd
 }
@@ -153,6 +156,43 @@ func TestHelperProcess(t *testing.T) {
 	}
 
 	if os.Args[2] == "git" {
+		switch os.Args[3] {
+		case "status":
+			fmt.Fprint(os.Stdout, os.Getenv("GO_HELPER_GIT_STATUS"))
+			os.Exit(0)
+		case "rev-parse":
+			if os.Args[len(os.Args)-1] == "@{u}" {
+				// Like git rev-parse, fail when there is no upstream.
+				upstream := os.Getenv("GO_HELPER_GIT_UPSTREAM")
+				if upstream == "" {
+					os.Exit(128)
+				}
+				fmt.Fprintln(os.Stdout, upstream)
+				os.Exit(0)
+			}
+
+			branch := os.Getenv("GO_HELPER_GIT_BRANCH")
+			if branch == "" {
+				branch = "main"
+			}
+			fmt.Fprintln(os.Stdout, branch)
+			os.Exit(0)
+		case "config":
+			// Like git config, exit 1 when the key is not set.
+			remote := os.Getenv("GO_HELPER_GIT_REMOTE")
+			if remote == "" {
+				os.Exit(1)
+			}
+			fmt.Fprintln(os.Stdout, remote)
+			os.Exit(0)
+		}
+
+		// A prompt would hang the push, see runCommand.
+		if os.Getenv("GIT_TERMINAL_PROMPT") != "0" {
+			fmt.Fprintln(os.Stderr, "terminal prompts enabled")
+			os.Exit(1)
+		}
+
 		fmt.Fprintln(os.Stdout, "Everything up-to-date")
 		os.Exit(0)
 	}
@@ -166,15 +206,24 @@ func TestRunKill(t *testing.T) {
 		proj   string
 		sig    syscall.Signal
 		expErr error
+		expMsg string
 	}{
-		{"SIGINT", "./testdata/tool", syscall.SIGINT, ErrSignal},
-		{"SIGTERM", "./testdata/tool", syscall.SIGTERM, ErrSignal},
-		{"SIGQUIT", "./testdata/tool", syscall.SIGQUIT, nil},
+		{"SIGINT", "./testdata/tool", syscall.SIGINT, ErrSignal, `interrupt: Exiting: interrupted "git push": received signal`},
+		{"SIGTERM", "./testdata/tool", syscall.SIGTERM, ErrSignal, `terminated: Exiting: interrupted "git push": received signal`},
+		{"SIGQUIT", "./testdata/tool", syscall.SIGQUIT, nil, ""},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			command = mockCmdTimeout
+			// Only the push step runs its commands through command, so
+			// the first call means the build and tests before it are done.
+			started := make(chan struct{})
+			var once sync.Once
+			command = func(exe string, args ...string) *exec.Cmd {
+				once.Do(func() { close(started) })
+				return mockCmdTimeout(exe, args...)
+			}
+
 			errCh := make(chan error)
 			ignSigCh := make(chan os.Signal, 1)
 			expSigCh := make(chan os.Signal, 1)
@@ -185,14 +234,14 @@ func TestRunKill(t *testing.T) {
 			signal.Notify(expSigCh, tt.sig)
 			defer signal.Stop(expSigCh)
 
-			go func() {
-				errCh <- run(tt.proj, ioutil.Discard)
-			}()
+			go func(proj string) {
+				errCh <- run(config{proj: proj}, ioutil.Discard)
+			}(tt.proj)
 
-			go func() {
-				time.Sleep(2 * time.Second)
-				syscall.Kill(syscall.Getpid(), tt.sig)
-			}()
+			go func(sig syscall.Signal) {
+				<-started
+				syscall.Kill(syscall.Getpid(), sig)
+			}(tt.sig)
 
 			select {
 			case err := <-errCh:
@@ -204,6 +253,9 @@ func TestRunKill(t *testing.T) {
 				if !errors.Is(err, tt.expErr) {
 					t.Errorf("Expected error: %q. got %q", tt.expErr, err)
 				}
+				if err.Error() != tt.expMsg {
+					t.Errorf("Expected message: %q. got %q", tt.expMsg, err)
+				}
 				select {
 				case rec := <-expSigCh:
 					if rec != tt.sig {
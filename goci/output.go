@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+)
+
+// outputTail is the number of output lines kept for error messages.
+const outputTail = 20
+
+// prefixWriter writes each line to out with a prefix. Partial lines are
+// held back until they are complete or flushed, so lines written from
+// several streams are not mixed.
+type prefixWriter struct {
+	mu     sync.Mutex
+	out    io.Writer
+	prefix string
+	buf    []byte
+}
+
+func newPrefixWriter(out io.Writer, name string) *prefixWriter {
+	return &prefixWriter{out: out, prefix: "[" + name + "] "}
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+
+		if err := w.writeLine(w.buf[:i+1]); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[i+1:]
+	}
+
+	return len(p), nil
+}
+
+// Flush writes a trailing partial line.
+func (w *prefixWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	err := w.writeLine(append(w.buf, '\n'))
+	w.buf = nil
+	return err
+}
+
+func (w *prefixWriter) writeLine(line []byte) error {
+	_, err := w.out.Write(append([]byte(w.prefix), line...))
+	return err
+}
+
+// syncWriter serializes writes to out from steps running concurrently.
+// Together with prefixWriter, which writes whole lines, it keeps their
+// output readable.
+type syncWriter struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.out.Write(p)
+}
+
+// tailBuffer keeps the last lines written to it.
+type tailBuffer struct {
+	mu    sync.Mutex
+	lines []string
+	max   int
+	part  string
+}
+
+func newTailBuffer(max int) *tailBuffer {
+	return &tailBuffer{max: max}
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	lines := strings.Split(t.part+string(p), "\n")
+	t.part = lines[len(lines)-1]
+
+	t.lines = append(t.lines, lines[:len(lines)-1]...)
+	if len(t.lines) > t.max {
+		t.lines = t.lines[len(t.lines)-t.max:]
+	}
+
+	return len(p), nil
+}
+
+// String returns the kept lines, including a trailing partial line.
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	lines := t.lines
+	if t.part != "" {
+		lines = append(lines[:len(lines):len(lines)], t.part)
+		if len(lines) > t.max {
+			lines = lines[1:]
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// stepOutput is where a running step's combined output goes: streamed to
+// out with the step name as prefix, and kept for the error message.
+type stepOutput struct {
+	stream *prefixWriter
+	tail   *tailBuffer
+	io.Writer
+}
+
+func newStepOutput(out io.Writer, name string) *stepOutput {
+	o := &stepOutput{
+		stream: newPrefixWriter(out, name),
+		tail:   newTailBuffer(outputTail),
+	}
+	o.Writer = io.MultiWriter(o.stream, o.tail)
+
+	return o
+}
+
+// close flushes the stream and returns the tail of the output.
+func (o *stepOutput) close() string {
+	o.stream.Flush()
+	return o.tail.String()
+}
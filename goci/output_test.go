@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := newPrefixWriter(&out, "go test")
+
+	for _, s := range []string{"=== RUN ", "TestAdd\n--- PASS", ": TestAdd\n", "ok"} {
+		if _, err := w.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exp := "[go test] === RUN TestAdd\n[go test] --- PASS: TestAdd\n"
+	if out.String() != exp {
+		t.Errorf("Expected %q, got %q instead", exp, out.String())
+	}
+
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	exp += "[go test] ok\n"
+	if out.String() != exp {
+		t.Errorf("Expected %q, got %q instead", exp, out.String())
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	tb := newTailBuffer(3)
+	for i := 1; i <= 5; i++ {
+		fmt.Fprintf(tb, "line %d\n", i)
+	}
+
+	exp := "line 3\nline 4\nline 5"
+	if tb.String() != exp {
+		t.Errorf("Expected %q, got %q instead", exp, tb.String())
+	}
+
+	fmt.Fprint(tb, "partial")
+
+	exp = "line 4\nline 5\npartial"
+	if tb.String() != exp {
+		t.Errorf("Expected %q, got %q instead", exp, tb.String())
+	}
+}
+
+func TestRunStream(t *testing.T) {
+	data := `steps:
+  - name: greet
+    command: sh
+    args: [-c, 'echo hello; echo warning >&2']
+  - name: compile
+    command: sh
+    args: [-c, 'echo compiling; echo "add.go:3: undefined: x" >&2; exit 2']
+`
+	pipeline := filepath.Join(t.TempDir(), "pipeline.yaml")
+	if err := os.WriteFile(pipeline, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name  string
+		quiet bool
+		out   string
+	}{
+		{name: "Stream", out: "[greet] hello\n[greet] warning\ngreet: SUCCESS\n[compile] compiling\n[compile] add.go:3: undefined: x\n"},
+		{name: "Quiet", quiet: true, out: "greet: SUCCESS\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := run(config{proj: "./testdata/tool", pipeline: pipeline, quiet: tt.quiet}, &out)
+
+			if !errors.Is(err, &stepErr{step: "compile"}) {
+				t.Fatalf("Expected error %q, got %q instead", &stepErr{step: "compile"}, err)
+			}
+
+			expTail := "Output:\ncompiling\nadd.go:3: undefined: x"
+			if !strings.HasSuffix(err.Error(), expTail) {
+				t.Errorf("Expected error ending in %q, got %q instead", expTail, err)
+			}
+
+			if out.String() != tt.out {
+				t.Errorf("Expected output: %q. Got %q", tt.out, out.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// pipelineFiles are looked up in the project directory, in this order,
+// when no pipeline file is given.
+var pipelineFiles = []string{".goci.yaml", ".goci.yml", ".goci.json"}
+
+// stepConfig describes one step of a pipeline file.
+type stepConfig struct {
+	Name    string            `yaml:"name"`
+	Command string            `yaml:"command"`
+	Args    []string          `yaml:"args"`
+	Dir     string            `yaml:"dir"`
+	Kind    string            `yaml:"kind"`
+	Timeout time.Duration     `yaml:"timeout"`
+	Env     map[string]string `yaml:"env"`
+	Message string            `yaml:"message"`
+	Needs   []string          `yaml:"needs"`
+	Remote  string            `yaml:"remote"`
+	Branch  string            `yaml:"branch"`
+}
+
+// pipelineConfig is the content of a YAML or JSON pipeline file.
+type pipelineConfig struct {
+	Steps []stepConfig `yaml:"steps"`
+}
+
+// stage is a pipeline step with the names of the steps it needs to
+// succeed before it runs.
+type stage struct {
+	name  string
+	needs []string
+	executer
+}
+
+func defaultPipeline(proj string, push pushOptions) []stage {
+	pipeline := make([]stage, 4)
+
+	pipeline[0] = stage{name: "go build", executer: newStep(
+		"go build",
+		"go",
+		"Go Build: SUCCESS",
+		proj,
+		[]string{"build", ".", "errors"},
+	)}
+
+	pipeline[1] = stage{name: "go test", executer: newStep(
+		"go test",
+		"go",
+		"Go Test: SUCCESS",
+		proj,
+		[]string{"test", "-v"},
+	)}
+
+	pipeline[2] = stage{name: "go fmt", executer: newExceptionStep(
+		"go fmt",
+		"gofmt",
+		"Gofmt: SUCCESS",
+		proj,
+		[]string{"-l", "."},
+	)}
+
+	pipeline[3] = stage{name: "git push", executer: newPushStep(
+		"git push",
+		"Git Push: SUCCESS",
+		proj,
+		push,
+		10*time.Second,
+	)}
+
+	return sequential(pipeline)
+}
+
+// sequential makes each stage need the one before it, so the stages run
+// one after the other.
+func sequential(pipeline []stage) []stage {
+	for i := 1; i < len(pipeline); i++ {
+		pipeline[i].needs = []string{pipeline[i-1].name}
+	}
+
+	return pipeline
+}
+
+// findPipelineFile returns the first pipeline file found in proj, or ""
+// when there is none.
+func findPipelineFile(proj string) string {
+	for _, name := range pipelineFiles {
+		path := filepath.Join(proj, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+
+	return ""
+}
+
+// loadPipeline builds the pipeline from file, or from a pipeline file in
+// proj when file is empty. Without one, it returns the default pipeline.
+//
+// When no step of the file declares needs, the steps run in file order.
+// Otherwise each step waits only for the steps it needs, and steps with
+// no needs start right away.
+//
+// The remote and branch in push override those of push steps in the file.
+func loadPipeline(proj, file string, push pushOptions) ([]stage, error) {
+	if file == "" {
+		if file = findPipelineFile(proj); file == "" {
+			return defaultPipeline(proj, push), nil
+		}
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// YAML is a superset of JSON, so one decoder reads both formats.
+	var pc pipelineConfig
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&pc); err != nil {
+		return nil, fmt.Errorf("pipeline file %s: %v: %w", file, err, ErrValidation)
+	}
+
+	if len(pc.Steps) == 0 {
+		return nil, fmt.Errorf("pipeline file %s: no steps: %w", file, ErrValidation)
+	}
+
+	pipeline := make([]stage, 0, len(pc.Steps))
+	dag := false
+	for i, sc := range pc.Steps {
+		s, err := sc.executer(proj, push)
+		if err != nil {
+			return nil, fmt.Errorf("pipeline file %s: step %d: %w", file, i+1, err)
+		}
+
+		pipeline = append(pipeline, stage{name: sc.Name, needs: sc.Needs, executer: s})
+		dag = dag || len(sc.Needs) > 0
+	}
+
+	if !dag {
+		pipeline = sequential(pipeline)
+	}
+
+	if err := checkNeeds(pipeline); err != nil {
+		return nil, fmt.Errorf("pipeline file %s: %w", file, err)
+	}
+
+	return pipeline, nil
+}
+
+// checkNeeds verifies that step names are unique, that every need names
+// a step, and that no step needs itself, directly or through others.
+func checkNeeds(pipeline []stage) error {
+	index := make(map[string]int, len(pipeline))
+	for i, s := range pipeline {
+		if _, ok := index[s.name]; ok {
+			return fmt.Errorf("duplicate step %q: %w", s.name, ErrValidation)
+		}
+		index[s.name] = i
+	}
+
+	for _, s := range pipeline {
+		for _, n := range s.needs {
+			if _, ok := index[n]; !ok {
+				return fmt.Errorf("%s: needs unknown step %q: %w", s.name, n, ErrValidation)
+			}
+		}
+	}
+
+	// Depth-first search for a cycle: a step reached again while its own
+	// needs are still being visited.
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(pipeline))
+
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case visiting:
+			return fmt.Errorf("%s: dependency cycle: %w", pipeline[i].name, ErrValidation)
+		case visited:
+			return nil
+		}
+
+		state[i] = visiting
+		for _, n := range pipeline[i].needs {
+			if err := visit(index[n]); err != nil {
+				return err
+			}
+		}
+		state[i] = visited
+
+		return nil
+	}
+
+	for i := range pipeline {
+		if err := visit(i); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// executer builds the step described by sc. Relative directories are
+// relative to the project directory.
+func (sc stepConfig) executer(proj string, push pushOptions) (executer, error) {
+	if sc.Kind == "push" {
+		return sc.pushStep(proj, push)
+	}
+
+	if sc.Name == "" || sc.Command == "" {
+		return nil, fmt.Errorf("name and command are required: %w", ErrValidation)
+	}
+
+	if sc.Remote != "" || sc.Branch != "" {
+		return nil, fmt.Errorf("%s: remote and branch need kind push: %w", sc.Name, ErrValidation)
+	}
+
+	dir := sc.dir(proj)
+	msg := sc.message()
+
+	if sc.Timeout != 0 && sc.Kind != "timeout" {
+		return nil, fmt.Errorf("%s: timeout needs kind timeout: %w", sc.Name, ErrValidation)
+	}
+
+	s := newStep(sc.Name, sc.Command, msg, dir, sc.Args)
+	s.env = envList(sc.Env)
+
+	switch sc.Kind {
+	case "", "plain":
+		return s, nil
+	case "exception":
+		es := newExceptionStep(sc.Name, sc.Command, msg, dir, sc.Args)
+		es.env = s.env
+		return es, nil
+	case "timeout":
+		if sc.Timeout < 0 {
+			return nil, fmt.Errorf("%s: negative timeout: %w", sc.Name, ErrValidation)
+		}
+
+		ts := newTimeoutStep(sc.Name, sc.Command, msg, dir, sc.Args, sc.Timeout)
+		ts.env = s.env
+		return ts, nil
+	}
+
+	return nil, fmt.Errorf("%s: unknown kind %q: %w", sc.Name, sc.Kind, ErrValidation)
+}
+
+// pushStep builds a push step, which runs git itself. The remote and
+// branch in push take precedence over those of sc.
+func (sc stepConfig) pushStep(proj string, push pushOptions) (executer, error) {
+	if sc.Name == "" {
+		return nil, fmt.Errorf("name is required: %w", ErrValidation)
+	}
+
+	if sc.Command != "" || len(sc.Args) > 0 {
+		return nil, fmt.Errorf("%s: push steps take no command or args: %w", sc.Name, ErrValidation)
+	}
+
+	if sc.Timeout < 0 {
+		return nil, fmt.Errorf("%s: negative timeout: %w", sc.Name, ErrValidation)
+	}
+
+	opts := pushOptions{remote: sc.Remote, branch: sc.Branch, skip: push.skip}
+	if push.remote != "" {
+		opts.remote = push.remote
+	}
+	if push.branch != "" {
+		opts.branch = push.branch
+	}
+
+	s := newPushStep(sc.Name, sc.message(), sc.dir(proj), opts, sc.Timeout)
+	s.env = envList(sc.Env)
+
+	return s, nil
+}
+
+// dir returns the directory the step runs in.
+func (sc stepConfig) dir(proj string) string {
+	if sc.Dir == "" {
+		return proj
+	}
+
+	if filepath.IsAbs(sc.Dir) {
+		return sc.Dir
+	}
+
+	return filepath.Join(proj, sc.Dir)
+}
+
+// message returns the message printed when the step succeeds.
+func (sc stepConfig) message() string {
+	if sc.Message != "" {
+		return sc.Message
+	}
+
+	return fmt.Sprintf("%s: SUCCESS", sc.Name)
+}
+
+// envList turns env into sorted KEY=VALUE pairs.
+func envList(env map[string]string) []string {
+	if len(env) == 0 {
+		return nil
+	}
+
+	list := make([]string, 0, len(env))
+	for k, v := range env {
+		list = append(list, k+"="+v)
+	}
+	sort.Strings(list)
+
+	return list
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadPipeline(t *testing.T) {
+	yamlPipeline := `steps:
+  - name: vet
+    command: go
+    args: [vet, ./...]
+  - name: fmt
+    command: gofmt
+    args: [-l, .]
+    kind: exception
+    message: "Gofmt: OK"
+  - name: integration
+    command: go
+    args: [test, -tags, integration]
+    dir: tests
+    kind: timeout
+    timeout: 2m
+    env:
+      GOFLAGS: -count=1
+      CGO_ENABLED: "0"
+`
+	jsonPipeline := `{"steps": [{"name": "build", "command": "go", "args": ["build"], "dir": "/src"}]}`
+	dagPipeline := `steps:
+  - {name: vet, command: go, args: [vet, ./...]}
+  - {name: push, command: git, args: [push], needs: [vet, fmt]}
+  - {name: fmt, command: gofmt, args: [-l, .]}
+`
+
+	vet := newStep("vet", "go", "vet: SUCCESS", "proj", []string{"vet", "./..."})
+	fmtStep := newExceptionStep("fmt", "gofmt", "Gofmt: OK", "proj", []string{"-l", "."})
+	integration := newTimeoutStep("integration", "go", "integration: SUCCESS", filepath.Join("proj", "tests"),
+		[]string{"test", "-tags", "integration"}, 2*time.Minute)
+	integration.env = []string{"CGO_ENABLED=0", "GOFLAGS=-count=1"}
+
+	var tests = []struct {
+		name   string
+		file   string
+		data   string
+		exp    []stage
+		expErr error
+	}{
+		{name: "YAML", file: ".goci.yaml", data: yamlPipeline, exp: []stage{
+			{name: "vet", executer: vet},
+			{name: "fmt", needs: []string{"vet"}, executer: fmtStep},
+			{name: "integration", needs: []string{"fmt"}, executer: integration},
+		}},
+		{name: "JSON", file: ".goci.json", data: jsonPipeline,
+			exp: []stage{{name: "build", executer: newStep("build", "go", "build: SUCCESS", "/src", []string{"build"})}}},
+		{name: "Needs", file: ".goci.yaml", data: dagPipeline, exp: []stage{
+			{name: "vet", executer: vet},
+			{name: "push", needs: []string{"vet", "fmt"}, executer: newStep("push", "git", "push: SUCCESS", "proj", []string{"push"})},
+			{name: "fmt", executer: newStep("fmt", "gofmt", "fmt: SUCCESS", "proj", []string{"-l", "."})},
+		}},
+		{name: "Push", file: ".goci.yaml", data: "steps: [{name: push, kind: push, remote: upstream, branch: main, timeout: 1m}]",
+			exp: []stage{{name: "push", executer: newPushStep("push", "push: SUCCESS", "proj",
+				pushOptions{remote: "fork", branch: "main"}, time.Minute)}}},
+		{name: "Default", exp: defaultPipeline("proj", pushOptions{remote: "fork"})},
+		{name: "FailKind", file: ".goci.yaml", data: "steps: [{name: a, command: b, kind: parallel}]", expErr: ErrValidation},
+		{name: "FailCommand", file: ".goci.yaml", data: "steps: [{name: a}]", expErr: ErrValidation},
+		{name: "FailTimeoutKind", file: ".goci.yaml", data: "steps: [{name: a, command: b, timeout: 1s}]", expErr: ErrValidation},
+		{name: "FailUnknownField", file: ".goci.yaml", data: "steps: [{name: a, command: b, retries: 3}]", expErr: ErrValidation},
+		{name: "FailUnknownNeed", file: ".goci.yaml", data: "steps: [{name: a, command: b, needs: [c]}]", expErr: ErrValidation},
+		{name: "FailDuplicateName", file: ".goci.yaml", data: "steps: [{name: a, command: b}, {name: a, command: c}]", expErr: ErrValidation},
+		{name: "FailCycle", file: ".goci.yaml",
+			data: "steps: [{name: a, command: b, needs: [c]}, {name: b, command: b, needs: [a]}, {name: c, command: b, needs: [b]}]", expErr: ErrValidation},
+		{name: "FailSelfNeed", file: ".goci.yaml", data: "steps: [{name: a, command: b, needs: [a]}]", expErr: ErrValidation},
+		{name: "FailPushCommand", file: ".goci.yaml", data: "steps: [{name: a, kind: push, command: git}]", expErr: ErrValidation},
+		{name: "FailRemoteKind", file: ".goci.yaml", data: "steps: [{name: a, command: b, remote: origin}]", expErr: ErrValidation},
+		{name: "FailNoSteps", file: ".goci.json", data: `{"steps": []}`, expErr: ErrValidation},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.file != "" {
+				if err := os.WriteFile(filepath.Join(dir, tt.file), []byte(tt.data), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Steps are built relative to the project directory "proj",
+			// while the file is read from dir.
+			file := findPipelineFile(dir)
+			pipeline, err := loadPipeline("proj", file, pushOptions{remote: "fork"})
+
+			if tt.expErr != nil {
+				if !errors.Is(err, tt.expErr) {
+					t.Errorf("Expected error %q, got %q instead", tt.expErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %q", err)
+			}
+
+			if !reflect.DeepEqual(pipeline, tt.exp) {
+				t.Errorf("Expected pipeline %+v, got %+v instead", tt.exp, pipeline)
+			}
+		})
+	}
+}
+
+func TestRunPipelineFile(t *testing.T) {
+	pipeline := filepath.Join(t.TempDir(), "pipeline.yaml")
+	data := `steps:
+  - name: go vet
+    command: go
+    args: [vet, .]
+    message: "Go Vet: SUCCESS"
+  - name: env
+    command: sh
+    args: [-c, 'test "$GOCI_STAGE" = ci']
+    env:
+      GOCI_STAGE: ci
+`
+	if err := os.WriteFile(pipeline, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := run(config{proj: "./testdata/tool", pipeline: pipeline, quiet: true}, &out); err != nil {
+		t.Fatalf("Unexpected error: %q", err)
+	}
+
+	exp := "Go Vet: SUCCESS\nenv: SUCCESS\n"
+	if out.String() != exp {
+		t.Errorf("Expected output: %q. Got %q", exp, out.String())
+	}
+}
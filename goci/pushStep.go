@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// pushOptions select where a push step pushes to. An empty remote or
+// branch is detected from the repository.
+type pushOptions struct {
+	remote string
+	branch string
+	skip   bool
+}
+
+// pushStep pushes the current branch with git, after making sure there
+// are no uncommitted changes.
+type pushStep struct {
+	timeoutStep
+	pushOptions
+}
+
+func newPushStep(name, message, proj string, opts pushOptions, timeout time.Duration) pushStep {
+	s := pushStep{}
+
+	s.timeoutStep = newTimeoutStep(name, "git", message, proj, nil, timeout)
+	s.pushOptions = opts
+
+	return s
+}
+
+func (s pushStep) execute(ctx context.Context, out io.Writer) (string, error) {
+	if s.skip {
+		return fmt.Sprintf("%s: SKIPPED", s.name), nil
+	}
+
+	// Commands run in their own process group, outside the terminal's
+	// foreground group, so git must not prompt for credentials: reading
+	// the terminal would stop it until the timeout. Failing fast is
+	// better, and the step's env can still turn prompts back on.
+	s.env = append([]string{"GIT_TERMINAL_PROMPT=0"}, s.env...)
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	status, err := s.git(ctx, "status", "--porcelain", "--untracked-files=no")
+	if err != nil {
+		return "", s.failure(ctx, "failed to check for uncommitted changes", err, status)
+	}
+
+	if status != "" {
+		return "", &stepErr{
+			step:   s.name,
+			msg:    "refusing to push",
+			cause:  ErrUncommitted,
+			output: status,
+		}
+	}
+
+	branch := s.branch
+	if branch == "" {
+		branch, err = s.git(ctx, "rev-parse", "--abbrev-ref", "HEAD")
+		if err != nil {
+			return "", s.failure(ctx, "failed to detect the current branch", err, branch)
+		}
+
+		if branch == "HEAD" {
+			return "", s.failure(ctx, "failed to detect the current branch",
+				fmt.Errorf("detached HEAD, set the branch to push: %w", ErrValidation), "")
+		}
+	}
+
+	// Without a remote, push to the branch's upstream, if any. A detected
+	// branch may track a branch with another name, so push HEAD to that.
+	remote, dest := s.remote, branch
+	if remote == "" {
+		remote, err = s.git(ctx, "config", "--get", "branch."+branch+".remote")
+		if err != nil || remote == "" {
+			remote = "origin"
+		} else if s.branch == "" {
+			upstream, err := s.git(ctx, "rev-parse", "--abbrev-ref", "--symbolic-full-name", "@{u}")
+			if err == nil && strings.HasPrefix(upstream, remote+"/") {
+				dest = "HEAD:" + strings.TrimPrefix(upstream, remote+"/")
+			}
+		}
+	}
+
+	push := s.timeoutStep
+	push.args = []string{"push", remote, dest}
+
+	return push.execute(ctx, out)
+}
+
+// git runs a git subcommand in the project directory and returns its
+// output without surrounding space, or its error output when it fails.
+func (s pushStep) git(ctx context.Context, args ...string) (string, error) {
+	cmd := command("git", args...)
+	cmd.Dir = s.proj
+	s.setEnv(cmd)
+
+	var out, errOut bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &errOut
+
+	if err := runCommand(ctx, cmd); err != nil {
+		return strings.TrimSpace(errOut.String()), err
+	}
+
+	return strings.TrimSpace(out.String()), nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPushStep(t *testing.T) {
+	var tests = []struct {
+		name     string
+		opts     pushOptions
+		env      []string
+		expCalls []string
+		expMsg   string
+		expErr   error
+	}{
+		{name: "Detect", expMsg: "Git Push: SUCCESS", expCalls: []string{
+			"status --porcelain --untracked-files=no",
+			"rev-parse --abbrev-ref HEAD",
+			"config --get branch.main.remote",
+			"push origin main",
+		}},
+		{name: "DetectUpstream", env: []string{"GO_HELPER_GIT_BRANCH=feature", "GO_HELPER_GIT_REMOTE=fork", "GO_HELPER_GIT_UPSTREAM=fork/feature"},
+			expMsg: "Git Push: SUCCESS", expCalls: []string{
+				"status --porcelain --untracked-files=no",
+				"rev-parse --abbrev-ref HEAD",
+				"config --get branch.feature.remote",
+				"rev-parse --abbrev-ref --symbolic-full-name @{u}",
+				"push fork HEAD:feature",
+			}},
+		{name: "DetectUpstreamRenamed", env: []string{"GO_HELPER_GIT_BRANCH=feature", "GO_HELPER_GIT_REMOTE=fork", "GO_HELPER_GIT_UPSTREAM=fork/main"},
+			expMsg: "Git Push: SUCCESS", expCalls: []string{
+				"status --porcelain --untracked-files=no",
+				"rev-parse --abbrev-ref HEAD",
+				"config --get branch.feature.remote",
+				"rev-parse --abbrev-ref --symbolic-full-name @{u}",
+				"push fork HEAD:main",
+			}},
+		{name: "DetectRemoteNoUpstream", env: []string{"GO_HELPER_GIT_BRANCH=feature", "GO_HELPER_GIT_REMOTE=fork"},
+			expMsg: "Git Push: SUCCESS", expCalls: []string{
+				"status --porcelain --untracked-files=no",
+				"rev-parse --abbrev-ref HEAD",
+				"config --get branch.feature.remote",
+				"rev-parse --abbrev-ref --symbolic-full-name @{u}",
+				"push fork feature",
+			}},
+		{name: "Options", opts: pushOptions{remote: "fork", branch: "dev"}, env: []string{"GO_HELPER_GIT_REMOTE=upstream"},
+			expMsg: "Git Push: SUCCESS", expCalls: []string{
+				"status --porcelain --untracked-files=no",
+				"push fork dev",
+			}},
+		{name: "Skip", opts: pushOptions{skip: true}, expMsg: "git push: SKIPPED"},
+		{name: "FailUncommitted", env: []string{"GO_HELPER_GIT_STATUS= M add.go\n"}, expErr: ErrUncommitted,
+			expCalls: []string{"status --porcelain --untracked-files=no"}},
+		{name: "FailDetached", env: []string{"GO_HELPER_GIT_BRANCH=HEAD"}, expErr: ErrValidation,
+			expCalls: []string{
+				"status --porcelain --untracked-files=no",
+				"rev-parse --abbrev-ref HEAD",
+			}},
+	}
+
+	defer func() { command = exec.Command }()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			command = func(exe string, args ...string) *exec.Cmd {
+				calls = append(calls, strings.Join(args, " "))
+
+				cmd := mockCmd(exe, args...)
+				cmd.Env = append(cmd.Env, tt.env...)
+				return cmd
+			}
+
+			s := newPushStep("git push", "Git Push: SUCCESS", "./testdata/tool", tt.opts, 10*time.Second)
+			msg, err := s.execute(context.Background(), io.Discard)
+
+			if tt.expErr != nil {
+				if !errors.Is(err, tt.expErr) {
+					t.Errorf("Expected error %q, got %q instead", tt.expErr, err)
+				}
+			} else if err != nil {
+				t.Fatalf("Unexpected error: %q", err)
+			}
+
+			if msg != tt.expMsg {
+				t.Errorf("Expected message %q, got %q instead", tt.expMsg, msg)
+			}
+
+			if !reflect.DeepEqual(calls, tt.expCalls) {
+				t.Errorf("Expected git calls %q, got %q instead", tt.expCalls, calls)
+			}
+		})
+	}
+}
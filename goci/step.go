@@ -1,6 +1,11 @@
 package main
 
-import "os/exec"
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+)
 
 type step struct {
 	name    string
@@ -8,6 +13,7 @@ type step struct {
 	args    []string
 	message string
 	proj    string
+	env     []string
 }
 
 func newStep(name, exe, message, proj string, args []string) step {
@@ -20,17 +26,51 @@ func newStep(name, exe, message, proj string, args []string) step {
 	}
 }
 
-func (s step) execute() (string, error) {
+func (s step) execute(ctx context.Context, out io.Writer) (string, error) {
 	cmd := exec.Command(s.exe, s.args...)
 	cmd.Dir = s.proj
+	s.setEnv(cmd)
 
-	if err := cmd.Run(); err != nil {
-		return "", &stepErr{
-			step:  s.name,
-			msg:   "failed to execute",
-			cause: err,
-		}
+	output := newStepOutput(out, s.name)
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	err := runCommand(ctx, cmd)
+	tail := output.close()
+
+	if err != nil {
+		return "", s.failure(ctx, "failed to execute", err, tail)
 	}
 
 	return s.message, nil
 }
+
+// failure builds the error of a failed command, telling a timeout or an
+// interruption apart from a failure of the command itself.
+func (s step) failure(ctx context.Context, msg string, err error, output string) error {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		msg, err = "failed timeout", context.DeadlineExceeded
+	case context.Canceled:
+		msg, err = "interrupted", context.Canceled
+	}
+
+	return &stepErr{
+		step:   s.name,
+		msg:    msg,
+		cause:  err,
+		output: output,
+	}
+}
+
+// setEnv adds the step's environment variables to the environment of cmd.
+func (s step) setEnv(cmd *exec.Cmd) {
+	if len(s.env) == 0 {
+		return
+	}
+
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, s.env...)
+}
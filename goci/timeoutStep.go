@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"io"
 	"os/exec"
 	"time"
 )
@@ -24,29 +25,25 @@ func newTimeoutStep(name, exe, message, proj string, args []string, timeout time
 	return s
 }
 
-var command = exec.CommandContext
+var command = exec.Command
 
-func (s timeoutStep) execute() (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+func (s timeoutStep) execute(ctx context.Context, out io.Writer) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
 	defer cancel()
 
-	cmd := command(ctx, s.exe, s.args...)
+	cmd := command(s.exe, s.args...)
 	cmd.Dir = s.proj
+	s.setEnv(cmd)
 
-	if err := cmd.Run(); err != nil {
-		if ctx.Err() == context.DeadlineExceeded {
-			return "", &stepErr{
-				step:  s.name,
-				msg:   "failed timeout",
-				cause: context.DeadlineExceeded,
-			}
-		}
-
-		return "", &stepErr{
-			step:  s.name,
-			msg:   "failed to execute",
-			cause: err,
-		}
+	output := newStepOutput(out, s.name)
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	err := runCommand(ctx, cmd)
+	tail := output.close()
+
+	if err != nil {
+		return "", s.failure(ctx, "failed to execute", err, tail)
 	}
 
 	return s.message, nil
@@ -0,0 +1,139 @@
+package main
+
+import "math"
+
+// accumulator folds values one at a time so that only a fixed amount of
+// state is kept per column, and accumulators from several files can be
+// merged. The sketch, the value counts for mode, the histogram and the
+// grid are only kept when an operation needs them.
+type accumulator struct {
+	count    uint64
+	sum      float64
+	min, max float64
+	mean, m2 float64
+	sketch   *sketch
+	counts   map[float64]uint64
+	hist     *histogram
+	grid     *grid
+}
+
+// accSpec records which optional state the requested operations need.
+// bounds are the boundaries of a histogram with explicit buckets.
+type accSpec struct {
+	sketch bool
+	counts bool
+	grid   bool
+	bounds []float64
+}
+
+func newAccumulator(spec accSpec) *accumulator {
+	a := &accumulator{
+		min: math.Inf(1),
+		max: math.Inf(-1),
+	}
+
+	if spec.sketch {
+		a.sketch = newSketch()
+	}
+
+	if spec.counts {
+		a.counts = make(map[float64]uint64)
+	}
+
+	if spec.bounds != nil {
+		a.hist = newHistogram(spec.bounds)
+	}
+
+	if spec.grid {
+		a.grid = newGrid()
+	}
+
+	return a
+}
+
+// newAccumulators returns an empty accumulator for each of n columns.
+func newAccumulators(n int, spec accSpec) []*accumulator {
+	accs := make([]*accumulator, n)
+	for i := range accs {
+		accs[i] = newAccumulator(spec)
+	}
+
+	return accs
+}
+
+func (a *accumulator) add(v float64) {
+	a.count++
+	a.sum += v
+	a.min = math.Min(a.min, v)
+	a.max = math.Max(a.max, v)
+
+	// Welford's online algorithm
+	delta := v - a.mean
+	a.mean += delta / float64(a.count)
+	a.m2 += delta * (v - a.mean)
+
+	if a.sketch != nil {
+		a.sketch.add(v)
+	}
+
+	if a.counts != nil {
+		a.counts[v]++
+	}
+
+	if a.hist != nil {
+		a.hist.add(v)
+	}
+
+	if a.grid != nil {
+		a.grid.add(v)
+	}
+}
+
+func (a *accumulator) merge(o *accumulator) {
+	if o.count == 0 {
+		return
+	}
+
+	if a.count == 0 {
+		a.mean, a.m2 = o.mean, o.m2
+	} else {
+		// Chan et al. parallel variance
+		n := float64(a.count + o.count)
+		delta := o.mean - a.mean
+		a.m2 += o.m2 + delta*delta*float64(a.count)*float64(o.count)/n
+		a.mean += delta * float64(o.count) / n
+	}
+
+	a.count += o.count
+	a.sum += o.sum
+	a.min = math.Min(a.min, o.min)
+	a.max = math.Max(a.max, o.max)
+
+	if a.sketch != nil && o.sketch != nil {
+		a.sketch.merge(o.sketch)
+	}
+
+	if a.counts != nil {
+		for v, n := range o.counts {
+			a.counts[v] += n
+		}
+	}
+
+	if a.hist != nil && o.hist != nil {
+		a.hist.merge(o.hist)
+	}
+
+	if a.grid != nil && o.grid != nil {
+		a.grid.merge(o.grid)
+	}
+}
+
+// accumulate folds data into a new accumulator with every optional state.
+func accumulate(data []float64) *accumulator {
+	a := newAccumulator(accSpec{sketch: true, counts: true})
+	for _, v := range data {
+		a.add(v)
+	}
+
+	return a
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestAccumulatorMerge(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	spec := accSpec{sketch: true, counts: true}
+
+	all := newAccumulator(spec)
+	parts := []*accumulator{newAccumulator(spec), newAccumulator(spec), newAccumulator(spec)}
+
+	for i := 0; i < 10000; i++ {
+		v := math.Round(r.NormFloat64()*50 + 200)
+		all.add(v)
+		parts[i%len(parts)].add(v)
+	}
+
+	merged := newAccumulator(spec)
+	for _, p := range parts {
+		merged.merge(p)
+	}
+
+	for name, op := range operations {
+		t.Run(name, func(t *testing.T) {
+			exp, res := op(all), op(merged)
+
+			if math.Abs(exp-res) > 1e-9*math.Abs(exp) {
+				t.Errorf("Expected %g, got %g instead", exp, res)
+			}
+		})
+	}
+}
+
+func TestAccumulatorEmpty(t *testing.T) {
+	a := newAccumulator(accSpec{sketch: true, counts: true})
+	a.merge(newAccumulator(accSpec{}))
+
+	for _, name := range []string{"min", "max", "avg", "variance", "p99", "mode"} {
+		if v := operations[name](a); !math.IsNaN(v) {
+			t.Errorf("Expected NaN for %s, got %g instead", name, v)
+		}
+	}
+
+	if v := count(a); v != 0 {
+		t.Errorf("Expected count 0, got %g instead", v)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxSampleLines is how many line numbers of skipped rows are kept for
+// the report.
+const maxSampleLines = 5
+
+// rowStats counts the data rows of an input and the rows skipped because
+// they could not be parsed.
+type rowStats struct {
+	rows    int
+	skipped int
+	lines   []int
+}
+
+func (s *rowStats) skip(line int) {
+	s.rows++
+	s.skipped++
+
+	if len(s.lines) < maxSampleLines {
+		s.lines = append(s.lines, line)
+	}
+}
+
+// sampleLines formats the sample line numbers, with an ellipsis when
+// more rows were skipped.
+func (s rowStats) sampleLines() string {
+	l := make([]string, len(s.lines))
+	for i, n := range s.lines {
+		l[i] = strconv.Itoa(n)
+	}
+
+	if s.skipped > len(s.lines) {
+		l = append(l, "...")
+	}
+
+	return strings.Join(l, ", ")
+}
+
+// badLimit is the number of skipped rows, or the fraction of the rows,
+// above which a file fails. Only a limit that is set applies, so the zero
+// value allows any number while a count of 0 allows none.
+type badLimit struct {
+	set   bool
+	count int
+	ratio float64
+}
+
+// parseBadLimit parses a count such as "10" or a percentage such as "5%".
+func parseBadLimit(s string) (badLimit, error) {
+	if s == "" {
+		return badLimit{}, nil
+	}
+
+	if strings.HasSuffix(s, "%") {
+		r, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil || r < 0 || r > 100 {
+			return badLimit{}, fmt.Errorf("%w: max bad rows %q", ErrInvalidFormat, s)
+		}
+
+		return badLimit{set: true, ratio: r / 100}, nil
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return badLimit{}, fmt.Errorf("%w: max bad rows %q", ErrInvalidFormat, s)
+	}
+
+	return badLimit{set: true, count: n}, nil
+}
+
+// exceeded reports whether s has more skipped rows than the limit allows.
+// The ratio can only be checked once all rows are counted. A ratio of 0
+// allows no skipped rows, the same as a count of 0.
+func (l badLimit) exceeded(s rowStats) bool {
+	if !l.set {
+		return false
+	}
+
+	if l.ratio > 0 {
+		return s.rows > 0 && float64(s.skipped)/float64(s.rows) > l.ratio
+	}
+
+	return s.skipped > l.count
+}
+
+func (l badLimit) err(s rowStats) error {
+	return fmt.Errorf("%w: skipped %d of %d rows, lines %s", ErrTooManyBadRows, s.skipped, s.rows, s.sampleLines())
+}
+
+// printSkipped reports the skipped rows of each file that had any, in the
+// order the files were given.
+func printSkipped(w io.Writer, filenames []string, stats map[string]rowStats) error {
+	for _, f := range filenames {
+		s, ok := stats[f]
+		if !ok || s.skipped == 0 {
+			continue
+		}
+
+		if _, err := fmt.Fprintf(w, "%s: skipped %d of %d rows, lines %s\n", f, s.skipped, s.rows, s.sampleLines()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
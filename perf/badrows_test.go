@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestReadRowsSkipBad(t *testing.T) {
+	csvData := `IP Address,Requests,Response Time
+192.168.0.199,2056,236
+192.168.0.88,n/a,220
+192.168.0.199,3054,
+192.168.0.100
+192.168.0.199,4133,218
+`
+	jsonData := `{"ip": "192.168.0.199", "time": 236}
+{"ip": "192.168.0.88", "time": "slow"}
+not json
+{"ip": "192.168.0.100", "time": null}`
+
+	tests := []struct {
+		name     string
+		opts     csvOptions
+		data     string
+		exp      string
+		expStats rowStats
+		expErr   error
+	}{
+		{name: "FailStrict", opts: csvOptions{}, data: csvData, expErr: ErrNotNumber},
+		{name: "Skip", opts: csvOptions{skipBad: true}, data: csvData,
+			exp: "[2056 236] [4133 218] ", expStats: rowStats{rows: 5, skipped: 3, lines: []int{3, 4, 5}}},
+		{name: "EmptyMissing", opts: csvOptions{skipBad: true, emptyMissing: true}, data: csvData,
+			exp: "[2056 236] [3054 NaN] [4133 218] ", expStats: rowStats{rows: 5, skipped: 2, lines: []int{3, 5}}},
+		{name: "FailEmptyNotSkipped", opts: csvOptions{emptyMissing: true}, data: csvData, expErr: ErrNotNumber},
+		{name: "UnderLimit", opts: csvOptions{skipBad: true, maxBad: badLimit{set: true, count: 3}}, data: csvData,
+			exp: "[2056 236] [4133 218] ", expStats: rowStats{rows: 5, skipped: 3, lines: []int{3, 4, 5}}},
+		{name: "FailCountLimit", opts: csvOptions{skipBad: true, maxBad: badLimit{set: true, count: 2}}, data: csvData, expErr: ErrTooManyBadRows},
+		{name: "FailRatioLimit", opts: csvOptions{skipBad: true, maxBad: badLimit{set: true, ratio: 0.5}}, data: csvData, expErr: ErrTooManyBadRows},
+		{name: "FailZeroLimit", opts: csvOptions{skipBad: true, maxBad: badLimit{set: true}}, data: csvData, expErr: ErrTooManyBadRows},
+		{name: "JSONL", opts: csvOptions{skipBad: true, emptyMissing: true, jsonl: true}, data: jsonData,
+			exp: "[236] [NaN] ", expStats: rowStats{rows: 4, skipped: 2, lines: []int{2, 3}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var res bytes.Buffer
+
+			tt.opts.header = true
+			tt.opts.cols = []column{{name: "Requests"}, {name: "Response Time"}}
+			if tt.opts.jsonl {
+				tt.opts.cols = []column{{name: "time"}}
+			}
+
+			stats, err := readRows(bytes.NewBufferString(tt.data), tt.opts, func(_ string, vals []float64) {
+				fmt.Fprintf(&res, "%v ", vals)
+			})
+
+			if tt.expErr != nil {
+				if !errors.Is(err, tt.expErr) {
+					t.Errorf("Expected error %q, got %q instead", tt.expErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %q", err)
+			}
+
+			if res.String() != tt.exp {
+				t.Errorf("Expected %q, got %q instead", tt.exp, res.String())
+			}
+
+			if fmt.Sprint(stats) != fmt.Sprint(tt.expStats) {
+				t.Errorf("Expected stats %v, got %v instead", tt.expStats, stats)
+			}
+		})
+	}
+}
+
+func TestParseBadLimit(t *testing.T) {
+	tests := []struct {
+		s      string
+		exp    badLimit
+		expErr bool
+	}{
+		{s: "", exp: badLimit{}},
+		{s: "10", exp: badLimit{set: true, count: 10}},
+		{s: "5%", exp: badLimit{set: true, ratio: 0.05}},
+		{s: "0", exp: badLimit{set: true}},
+		{s: "0%", exp: badLimit{set: true}},
+		{s: "-1", expErr: true},
+		{s: "120%", expErr: true},
+		{s: "few", expErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			l, err := parseBadLimit(tt.s)
+			if tt.expErr {
+				if !errors.Is(err, ErrInvalidFormat) {
+					t.Errorf("Expected error %q, got %q instead", ErrInvalidFormat, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %q", err)
+			}
+
+			if l != tt.exp {
+				t.Errorf("Expected %v, got %v instead", tt.exp, l)
+			}
+		})
+	}
+}
+
+func TestRunSkipBad(t *testing.T) {
+	var data strings.Builder
+	data.WriteString("IP Address,Response Time\n")
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(&data, "192.168.0.1,%d\n", 200+i)
+	}
+	for i := 0; i < 7; i++ {
+		data.WriteString("192.168.0.1,timeout\n")
+	}
+
+	files := []string{"-", "./testdata/example.csv"}
+
+	tests := []struct {
+		name   string
+		cfg    config
+		exp    string
+		expLog string
+		expErr error
+	}{
+		{name: "FailStrict", cfg: config{op: "count", col: "2"}, expErr: ErrNotNumber},
+		{name: "Skip", cfg: config{op: "count", col: "2", skipBad: true},
+			exp: "15\n", expLog: "-: skipped 7 of 17 rows, lines 12, 13, 14, 15, 16, ...\n"},
+		{name: "FailLimit", cfg: config{op: "count", col: "2", skipBad: true, maxBad: "5"}, expErr: ErrTooManyBadRows},
+		{name: "FailZeroLimit", cfg: config{op: "count", col: "2", skipBad: true, maxBad: "0"}, expErr: ErrTooManyBadRows},
+		{name: "FailZeroPercentLimit", cfg: config{op: "count", col: "2", skipBad: true, maxBad: "0%"}, expErr: ErrTooManyBadRows},
+		{name: "FailInvalidLimit", cfg: config{op: "count", col: "2", skipBad: true, maxBad: "lots"}, expErr: ErrInvalidFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var res, log bytes.Buffer
+
+			tt.cfg.stdin = strings.NewReader(data.String())
+			tt.cfg.errOut = &log
+
+			err := run(context.Background(), files, tt.cfg, &res)
+			if tt.expErr != nil {
+				if !errors.Is(err, tt.expErr) {
+					t.Errorf("Expected error %q, got %q instead", tt.expErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %q", err)
+			}
+
+			if res.String() != tt.exp {
+				t.Errorf("Expected %q, got %q instead", tt.exp, res.String())
+			}
+
+			if log.String() != tt.expLog {
+				t.Errorf("Expected log %q, got %q instead", tt.expLog, log.String())
+			}
+		})
+	}
+}
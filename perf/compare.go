@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// defaultAlpha is the significance level below which a change is shown.
+const defaultAlpha = 0.05
+
+// expandFiles splits a comma separated list of files or glob patterns.
+// Patterns that match nothing are kept so that opening them fails.
+func expandFiles(s string) ([]string, error) {
+	var files []string
+
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+
+		m, err := filepath.Glob(p)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrNoFiles, err)
+		}
+
+		if len(m) == 0 {
+			m = []string{p}
+		}
+		files = append(files, m...)
+	}
+
+	if len(files) == 0 {
+		return nil, ErrNoFiles
+	}
+
+	return files, nil
+}
+
+// mannWhitney returns the two-sided p-value of the Mann-Whitney U test
+// for two samples given as sketches, using the normal approximation with a
+// correction for ties. Values in the same sketch bucket count as ties, so
+// the test only tells apart values more than the sketch accuracy apart,
+// and memory stays bounded however many values there are.
+func mannWhitney(sa, sb *sketch) float64 {
+	na, nb := float64(sa.count), float64(sb.count)
+	if na == 0 || nb == 0 {
+		return math.NaN()
+	}
+
+	// Both sketches use the same buckets, so equal bucket values are the
+	// same bucket.
+	ca := make(map[float64]uint64)
+	cb := make(map[float64]uint64)
+	sa.each(func(v float64, n uint64) { ca[v] = n })
+	sb.each(func(v float64, n uint64) { cb[v] = n })
+
+	keys := make([]float64, 0, len(ca)+len(cb))
+	for v := range ca {
+		keys = append(keys, v)
+	}
+	for v := range cb {
+		if _, ok := ca[v]; !ok {
+			keys = append(keys, v)
+		}
+	}
+	sort.Float64s(keys)
+
+	// Sum the ranks of a, giving tied values their average rank.
+	var rankSum, ties, seen float64
+	for _, v := range keys {
+		t := float64(ca[v] + cb[v])
+		rankSum += float64(ca[v]) * (seen + (t+1)/2)
+		ties += t*t*t - t
+		seen += t
+	}
+
+	n := na + nb
+	u := rankSum - na*(na+1)/2
+	mu := na * nb / 2
+	sigma := math.Sqrt(na * nb / 12 * ((n + 1) - ties/(n*(n-1))))
+	if sigma == 0 || math.IsNaN(sigma) {
+		return 1
+	}
+
+	// Continuity correction towards the mean.
+	z := math.Max(math.Abs(u-mu)-0.5, 0) / sigma
+	return math.Erfc(z / math.Sqrt2)
+}
+
+// locationOps are the operations whose change the rank test can back.
+// It tests whether the values of one data set tend to be larger than the
+// other's, which says nothing about how many there are, their spread or
+// their extremes.
+var locationOps = map[string]bool{
+	"avg":    true,
+	"median": true,
+	"p50":    true,
+	"p90":    true,
+	"p99":    true,
+}
+
+// comparison is one statistic of one column in both data sets.
+type comparison struct {
+	col       column
+	op        string
+	base, new float64
+	nBase     uint64
+	nNew      uint64
+	p         float64
+}
+
+func (c comparison) delta() float64 {
+	return c.new - c.base
+}
+
+// change returns the relative change in percent.
+func (c comparison) change() float64 {
+	return c.delta() / math.Abs(c.base) * 100
+}
+
+// significant reports whether the distributions differ at level alpha.
+// It is never true without a p-value.
+func (c comparison) significant(alpha float64) bool {
+	return c.p < alpha
+}
+
+// compare applies the operations to the base and new accumulators of each
+// column. The location operations of a column share its p-value, the
+// others have none, as NaN.
+func compare(cols []column, names []string, funcs []statsFunc, base, cur []*accumulator) []comparison {
+	var res []comparison
+
+	for i, c := range cols {
+		colP := mannWhitney(base[i].sketch, cur[i].sketch)
+
+		for j, f := range funcs {
+			p := math.NaN()
+			if locationOps[names[j]] {
+				p = colP
+			}
+
+			res = append(res, comparison{
+				col:   c,
+				op:    names[j],
+				base:  f(base[i]),
+				new:   f(cur[i]),
+				nBase: base[i].count,
+				nNew:  cur[i].count,
+				p:     p,
+			})
+		}
+	}
+
+	return res
+}
+
+// printComparisons prints one row per statistic. The plain and table
+// formats show "~" instead of the change when it is not significant, like
+// benchstat, and "n/a" for a missing p-value. CSV leaves it empty.
+func printComparisons(out io.Writer, format string, prec int, alpha float64, res []comparison) error {
+	switch format {
+	case "csv":
+		w := csv.NewWriter(out)
+		if err := w.Write([]string{"column", "operation", "base", "new", "delta", "change %", "p", "significant", "base n", "new n"}); err != nil {
+			return err
+		}
+
+		for _, c := range res {
+			rec := []string{c.col.String(), c.op,
+				formatValue(c.base, prec), formatValue(c.new, prec),
+				formatValue(c.delta(), prec), formatValue(c.change(), prec),
+				pValue(c.p, 4, ""), strconv.FormatBool(c.significant(alpha)),
+				strconv.FormatUint(c.nBase, 10), strconv.FormatUint(c.nNew, 10),
+			}
+
+			if err := w.Write(rec); err != nil {
+				return err
+			}
+		}
+
+		w.Flush()
+		return w.Error()
+	case "json":
+		objs := make([]map[string]interface{}, 0, len(res))
+		for _, c := range res {
+			objs = append(objs, map[string]interface{}{
+				"column":      c.col.String(),
+				"operation":   c.op,
+				"base":        jsonValue(c.base, prec),
+				"new":         jsonValue(c.new, prec),
+				"delta":       jsonValue(c.delta(), prec),
+				"change":      jsonValue(c.change(), prec),
+				"p":           jsonValue(c.p, 4),
+				"significant": c.significant(alpha),
+				"base_n":      c.nBase,
+				"new_n":       c.nNew,
+			})
+		}
+
+		return json.NewEncoder(out).Encode(objs)
+	}
+
+	// Without a precision, the table shows 6 significant digits.
+	show := func(v float64) string {
+		if prec < 0 {
+			return formatValue(roundSignificant(v, 6), -1)
+		}
+
+		return formatValue(v, prec)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\tbase\tnew\tdelta\tchange\tp\tn")
+
+	for _, c := range res {
+		change := "~"
+		if c.significant(alpha) {
+			change = signed(formatValue(c.change(), 2)) + "%"
+		}
+
+		fmt.Fprintf(w, "%s %s\t%s\t%s\t%s\t%s\t%s\t%d+%d\n", c.col, c.op,
+			show(c.base), show(c.new), signed(show(c.delta())),
+			change, pValue(c.p, 3, "n/a"), c.nBase, c.nNew)
+	}
+
+	return w.Flush()
+}
+
+// pValue formats p with prec decimals, or returns none when there is no
+// p-value.
+func pValue(p float64, prec int, none string) string {
+	if math.IsNaN(p) {
+		return none
+	}
+
+	return formatValue(p, prec)
+}
+
+// signed prefixes positive numbers with a plus sign.
+func signed(s string) string {
+	if strings.HasPrefix(s, "-") || s == "NaN" {
+		return s
+	}
+
+	return "+" + s
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"testing"
+)
+
+func TestMannWhitney(t *testing.T) {
+	counts := func(vals ...float64) *sketch {
+		return accumulate(vals).sketch
+	}
+
+	tests := []struct {
+		name string
+		a, b *sketch
+		exp  float64
+	}{
+		{name: "Same", a: counts(1, 2, 3, 4, 5), b: counts(1, 2, 3, 4, 5), exp: 1},
+		{name: "AllTied", a: counts(7, 7, 7), b: counts(7, 7), exp: 1},
+		{name: "Shifted", a: counts(1, 2, 3, 4, 5, 6, 7, 8), b: counts(11, 12, 13, 14, 15, 16, 17, 18), exp: 0.0009},
+		// 218 and 220, and 236 and 238, share sketch buckets.
+		{name: "Ties", a: counts(236, 220, 226, 218, 238), b: counts(236, 238, 238, 238, 238, 238, 226, 238), exp: 0.0726},
+		{name: "Empty", a: counts(), b: counts(1, 2), exp: math.NaN()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := mannWhitney(tt.a, tt.b)
+			if math.IsNaN(tt.exp) {
+				if !math.IsNaN(p) {
+					t.Errorf("Expected NaN, got %v instead", p)
+				}
+				return
+			}
+
+			if math.Abs(p-tt.exp) > 0.0001 {
+				t.Errorf("Expected %.4f, got %.4f instead", tt.exp, p)
+			}
+		})
+	}
+}
+
+func TestRunCompare(t *testing.T) {
+	files := []string{"./testdata/example2.csv"}
+
+	tests := []struct {
+		name   string
+		cfg    config
+		exp    string
+		expErr error
+	}{
+		{name: "Table", cfg: config{op: "avg,max", col: "3,Bytes", base: "./testdata/example.csv"},
+			exp: "           base    new     delta   change  p      n\n" +
+				"col 3 avg  227.6   235.4   +7.8    +3.43%  0.045  5+20\n" +
+				"col 3 max  238     238     +0      ~       n/a    5+20\n" +
+				"Bytes avg  3434.4  3725.1  +290.7  +8.46%  0.015  5+20\n" +
+				"Bytes max  3822    3822    +0      ~       n/a    5+20\n"},
+		{name: "NotSignificant", cfg: config{op: "avg", col: "3", base: "./testdata/example.csv", alpha: 0.01},
+			exp: "           base   new    delta  change  p      n\n" +
+				"col 3 avg  227.6  235.4  +7.8   ~       0.045  5+20\n"},
+		{name: "CSV", cfg: config{op: "avg", col: "3", base: "./testdata/example.csv", format: "csv", precision: "2"},
+			exp: "column,operation,base,new,delta,change %,p,significant,base n,new n\n" +
+				"col 3,avg,227.60,235.40,7.80,3.43,0.0453,true,5,20\n"},
+		{name: "JSONGlob", cfg: config{op: "min", col: "3", base: "./testdata/example.c*", format: "json", precision: "1"},
+			exp: `[{"base":218,"base_n":5,"change":0,"column":"col 3","delta":0,"new":218,"new_n":20,"operation":"min","p":null,"significant":false}]` + "\n"},
+		{name: "NotLocation", cfg: config{op: "count,max,median", col: "3", base: "./testdata/example.csv"},
+			exp: "              base     new      delta     change  p      n\n" +
+				"col 3 count   5        20       +15       ~       n/a    5+20\n" +
+				"col 3 max     238      238      +0        ~       n/a    5+20\n" +
+				"col 3 median  228.179  237.492  +9.31248  +4.08%  0.045  5+20\n"},
+		{name: "NotLocationCSV", cfg: config{op: "count", col: "3", base: "./testdata/example.csv", format: "csv"},
+			exp: "column,operation,base,new,delta,change %,p,significant,base n,new n\n" +
+				"col 3,count,5,20,15,300,,false,5,20\n"},
+		{name: "FailBaseFile", cfg: config{op: "avg", col: "3", base: "./testdata/fakefile.csv"}, expErr: os.ErrNotExist},
+		{name: "FailGroup", cfg: config{op: "avg", col: "3", base: "./testdata/example.csv", group: "1"}, expErr: ErrInvalidFormat},
+		{name: "FailPerFile", cfg: config{op: "avg", col: "3", base: "./testdata/example.csv", perFile: true}, expErr: ErrInvalidFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var res bytes.Buffer
+
+			err := run(context.Background(), files, tt.cfg, &res)
+			if tt.expErr != nil {
+				if !errors.Is(err, tt.expErr) {
+					t.Errorf("Expected error %q, got %q instead", tt.expErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %q", err)
+			}
+
+			if res.String() != tt.exp {
+				t.Errorf("Expected %q, got %q instead", tt.exp, res.String())
+			}
+		})
+	}
+}
+
+func TestExpandFiles(t *testing.T) {
+	files, err := expandFiles("./testdata/example*.csv, ./testdata/missing.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := "[testdata/example.csv testdata/example2.csv ./testdata/missing.csv]"
+	if res := fmt.Sprint(files); res != exp {
+		t.Errorf("Expected %q, got %q instead", exp, res)
+	}
+
+	if _, err := expandFiles(" , "); !errors.Is(err, ErrNoFiles) {
+		t.Errorf("Expected error %q, got %q instead", ErrNoFiles, err)
+	}
+}
@@ -2,33 +2,310 @@ package main
 
 import (
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"strconv"
+	"strings"
 )
 
-func sum(data []float64) float64 {
-	sum := 0.0
+func sum(a *accumulator) float64 {
+	return a.sum
+}
+
+func avg(a *accumulator) float64 {
+	return a.sum / float64(a.count)
+}
+
+func min(a *accumulator) float64 {
+	if a.count == 0 {
+		return math.NaN()
+	}
+
+	return a.min
+}
+
+func max(a *accumulator) float64 {
+	if a.count == 0 {
+		return math.NaN()
+	}
+
+	return a.max
+}
+
+func count(a *accumulator) float64 {
+	return float64(a.count)
+}
+
+// variance returns the population variance.
+func variance(a *accumulator) float64 {
+	if a.count == 0 {
+		return math.NaN()
+	}
+
+	return a.m2 / float64(a.count)
+}
+
+func stddev(a *accumulator) float64 {
+	return math.Sqrt(variance(a))
+}
+
+// mode returns the most frequent value. Ties go to the smallest value.
+func mode(a *accumulator) float64 {
+	best, bestN := math.NaN(), uint64(0)
+	for v, n := range a.counts {
+		if n > bestN || (n == bestN && v < best) {
+			best, bestN = v, n
+		}
+	}
+
+	return best
+}
+
+// percentile returns a statsFunc reading the approximate q-quantile from
+// the accumulator's sketch.
+func percentile(q float64) statsFunc {
+	return func(a *accumulator) float64 {
+		return a.sketch.quantile(q)
+	}
+}
+
+type statsFunc func(a *accumulator) float64
+
+var operations = map[string]statsFunc{
+	"sum":      sum,
+	"avg":      avg,
+	"min":      min,
+	"max":      max,
+	"count":    count,
+	"median":   percentile(0.5),
+	"p50":      percentile(0.5),
+	"p90":      percentile(0.9),
+	"p99":      percentile(0.99),
+	"stddev":   stddev,
+	"variance": variance,
+	"mode":     mode,
+}
+
+// parseOps splits a comma separated list of operations.
+func parseOps(op string) ([]string, []statsFunc, error) {
+	names := strings.Split(op, ",")
+	funcs := make([]statsFunc, 0, len(names))
+
+	for i, name := range names {
+		name = strings.TrimSpace(name)
+		f, ok := operations[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: %s", ErrInvalidOperation, name)
+		}
+
+		names[i] = name
+		funcs = append(funcs, f)
+	}
+
+	return names, funcs, nil
+}
+
+// opsSpec returns the accumulator state needed by the named operations.
+func opsSpec(names []string) accSpec {
+	var spec accSpec
+
+	for _, n := range names {
+		switch n {
+		case "median", "p50", "p90", "p99":
+			spec.sketch = true
+		case "mode":
+			spec.counts = true
+		}
+	}
+
+	return spec
+}
+
+// column selects a CSV column by 1-based index or by header name.
+type column struct {
+	index int
+	name  string
+}
+
+func (c column) String() string {
+	if c.name != "" {
+		return c.name
+	}
+
+	return fmt.Sprintf("col %d", c.index)
+}
+
+// parseColumns splits a comma separated list of column numbers or header
+// names. Names require a header row.
+func parseColumns(s string, noHeader bool) ([]column, error) {
+	var cols []column
+
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
 
-	for _, v := range data {
-		sum += v
+		if n, err := strconv.Atoi(f); err == nil {
+			if n < 1 {
+				return nil, fmt.Errorf("%w: %d", ErrInvalidColumn, n)
+			}
+			cols = append(cols, column{index: n})
+			continue
+		}
+
+		if f == "" || noHeader {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidColumn, f)
+		}
+
+		cols = append(cols, column{name: f})
 	}
 
-	return sum
+	return cols, nil
 }
 
-func avg(data []float64) float64 {
-	return sum(data) / float64(len(data))
+// resolveColumns returns the 0-based index of each column, looking up
+// names in the header row.
+func resolveColumns(cols []column, header []string) ([]int, error) {
+	idx := make([]int, len(cols))
+
+	for i, c := range cols {
+		if c.name == "" {
+			idx[i] = c.index - 1
+			continue
+		}
+
+		idx[i] = -1
+		for j, h := range header {
+			if strings.EqualFold(strings.TrimSpace(h), c.name) {
+				idx[i] = j
+				break
+			}
+		}
+
+		if idx[i] < 0 {
+			return nil, fmt.Errorf("%w: no column named %q", ErrInvalidColumn, c.name)
+		}
+	}
+
+	return idx, nil
 }
 
-type statsFunc func(data []float64) float64
+// csvOptions describes which values to read from an input and how the
+// input is formatted.
+type csvOptions struct {
+	cols    []column
+	header  bool
+	group   *column
+	filters []filter
+
+	// window turns the group column into a timestamp, and the group key
+	// into the start of the time window holding it.
+	window *window
+
+	// jsonl reads JSON lines instead of CSV. Columns are then field
+	// paths such as "request.latency".
+	jsonl bool
+
+	comma            rune
+	comment          rune
+	lazyQuotes       bool
+	trimLeadingSpace bool
+
+	// skipBad skips rows that cannot be parsed instead of failing, up to
+	// maxBad. emptyMissing treats empty value cells as missing values
+	// rather than bad ones.
+	skipBad      bool
+	maxBad       badLimit
+	emptyMissing bool
+}
+
+// csv2acc folds the columns in opts from r into accumulators, keyed by the
+// value of the group column. Without a group column all values share the
+// key "". Memory does not grow with the number of rows. Missing values
+// are left out.
+func csv2acc(r io.Reader, opts csvOptions, spec accSpec) (map[string][]*accumulator, rowStats, error) {
+	groups := make(map[string][]*accumulator)
+
+	stats, err := readRows(r, opts, func(key string, vals []float64) {
+		accs, ok := groups[key]
+		if !ok {
+			accs = newAccumulators(len(vals), spec)
+			groups[key] = accs
+		}
+
+		for i, v := range vals {
+			if !math.IsNaN(v) {
+				accs[i].add(v)
+			}
+		}
+	})
+	if err != nil {
+		return nil, stats, err
+	}
+
+	return groups, stats, nil
+}
+
+// readRows streams the rows of r, calling emit with the group key and the
+// values of the selected columns for every row that passes the filters.
+// The values slice is reused between calls and holds NaN for missing
+// values. Gzip compressed input is detected and decompressed. The returned
+// stats count the data rows and the rows skipped with opts.skipBad.
+func readRows(r io.Reader, opts csvOptions, emit func(key string, vals []float64)) (rowStats, error) {
+	r, err := decompress(r)
+	if err != nil {
+		return rowStats{}, err
+	}
+
+	// Group, value and filter columns are resolved together:
+	// idx = [group] values... filters...
+	var cols []column
+	if opts.group != nil {
+		cols = append(cols, *opts.group)
+	}
+	cols = append(cols, opts.cols...)
+	for _, f := range opts.filters {
+		cols = append(cols, f.col)
+	}
+
+	p := rowProcessor{opts: opts, emit: emit, vals: make([]float64, len(opts.cols))}
+
+	if opts.jsonl {
+		err = readJSONL(r, cols, p.process, p.bad)
+	} else {
+		err = readCSV(r, opts, cols, p.process, p.bad)
+	}
+
+	if err == nil && opts.skipBad && opts.maxBad.exceeded(p.stats) {
+		err = opts.maxBad.err(p.stats)
+	}
+
+	return p.stats, err
+}
+
+// readCSV reads CSV rows from r and resolves cols to row indexes, using
+// the header row for column names. Errors in data rows are passed to bad
+// with their line number.
+func readCSV(r io.Reader, opts csvOptions, cols []column,
+	process func(row []string, idx []int) error, bad func(line int, err error) error) error {
 
-func csv2float(r io.Reader, column int) ([]float64, error) {
 	cr := csv.NewReader(r)
 	cr.ReuseRecord = true
-	column--
+	cr.LazyQuotes = opts.lazyQuotes
+	cr.TrimLeadingSpace = opts.trimLeadingSpace
+	cr.Comment = opts.comment
+	if opts.comma != 0 {
+		cr.Comma = opts.comma
+	}
+
+	var idx []int
+	if !opts.header {
+		var err error
+		if idx, err = resolveColumns(cols, nil); err != nil {
+			return err
+		}
+	}
 
-	var data []float64
 	for i := 0; ; i++ {
 		row, err := cr.Read()
 
@@ -36,25 +313,128 @@ func csv2float(r io.Reader, column int) ([]float64, error) {
 			break
 		}
 
+		var pe *csv.ParseError
+		if errors.As(err, &pe) && (i > 0 || !opts.header) {
+			if err := bad(pe.StartLine, fmt.Errorf("cannot read data from file: %w", err)); err != nil {
+				return err
+			}
+			continue
+		}
+
 		if err != nil {
-			return nil, fmt.Errorf("cannot read data from file: %w", err)
+			return fmt.Errorf("cannot read data from file: %w", err)
 		}
 
-		if i == 0 {
+		if i == 0 && opts.header {
+			if idx, err = resolveColumns(cols, row); err != nil {
+				return err
+			}
+			continue
+		}
+
+		line, _ := cr.FieldPos(0)
+
+		if err := checkColumns(row, idx); err != nil {
+			if err := bad(line, fmt.Errorf("line %d: %w", line, err)); err != nil {
+				return err
+			}
 			continue
 		}
 
-		if len(row) <= column {
-			return nil, fmt.Errorf("%w: file has only %d columns", ErrInvalidColumn, len(row))
+		if err := process(row, idx); err != nil {
+			if err := bad(line, fmt.Errorf("line %d: %w", line, err)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func checkColumns(row []string, idx []int) error {
+	for _, c := range idx {
+		if len(row) <= c {
+			return fmt.Errorf("%w: file has only %d columns", ErrInvalidColumn, len(row))
+		}
+	}
+
+	return nil
+}
+
+// rowProcessor applies filters to a row and emits its key and values. It
+// counts the rows it sees and decides which bad rows are skipped.
+type rowProcessor struct {
+	opts  csvOptions
+	emit  func(key string, vals []float64)
+	vals  []float64
+	stats rowStats
+}
+
+// bad skips the row at line when skipping is enabled and the limit is not
+// yet exceeded. Otherwise it returns err.
+func (p *rowProcessor) bad(line int, err error) error {
+	if !p.opts.skipBad {
+		return err
+	}
+
+	p.stats.skip(line)
+
+	if p.opts.maxBad.ratio == 0 && p.opts.maxBad.exceeded(p.stats) {
+		return p.opts.maxBad.err(p.stats)
+	}
+
+	return nil
+}
+
+func (p *rowProcessor) process(row []string, idx []int) error {
+	nFilters := len(p.opts.filters)
+
+	filterIdx := idx[len(idx)-nFilters:]
+	for j, f := range p.opts.filters {
+		v := row[filterIdx[j]]
+		if p.opts.emptyMissing && strings.TrimSpace(v) == "" {
+			// A missing value matches no condition.
+			p.stats.rows++
+			return nil
+		}
+
+		ok, err := f.match(v)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			p.stats.rows++
+			return nil
+		}
+	}
+
+	key, valIdx := "", idx[:len(idx)-nFilters]
+	if p.opts.group != nil {
+		key, valIdx = row[valIdx[0]], valIdx[1:]
+	}
+
+	if p.opts.window != nil {
+		var err error
+		if key, err = p.opts.window.key(key); err != nil {
+			return err
+		}
+	}
+
+	for j, c := range valIdx {
+		if p.opts.emptyMissing && strings.TrimSpace(row[c]) == "" {
+			p.vals[j] = math.NaN()
+			continue
 		}
 
-		v, err := strconv.ParseFloat(row[column], 64)
+		v, err := strconv.ParseFloat(row[c], 64)
 		if err != nil {
-			return nil, fmt.Errorf("%w: %s", ErrNotNumber, err)
+			return fmt.Errorf("%w: %s", ErrNotNumber, err)
 		}
 
-		data = append(data, v)
+		p.vals[j] = v
 	}
 
-	return data, nil
+	p.stats.rows++
+	p.emit(key, p.vals)
+	return nil
 }
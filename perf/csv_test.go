@@ -5,6 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"math/rand"
+	"sort"
 	"testing"
 	"testing/iotest"
 )
@@ -24,13 +27,17 @@ func TestOperations(t *testing.T) {
 	}{
 		{"Sum", sum, []float64{300, 85.927, -30, 436}},
 		{"Avg", avg, []float64{37.5, 6.609769230769231, -15, 72.666666666666666}},
+		{"Min", min, []float64{10, 2.2, -20, 37}},
+		{"Max", max, []float64{100, 12.287, -10, 129}},
+		{"Count", count, []float64{8, 13, 2, 6}},
+		{"Mode", mode, []float64{30, 2.2, -20, 37}},
 	}
 
 	for _, tt := range tests {
 		for k, exp := range tt.exp {
 			name := fmt.Sprintf("%sData%d", tt.name, k)
 			t.Run(name, func(t *testing.T) {
-				res := tt.op(data[k])
+				res := tt.op(accumulate(data[k]))
 
 				if res != exp {
 					t.Errorf("Expected %g, got %g instead", exp, res)
@@ -40,6 +47,121 @@ func TestOperations(t *testing.T) {
 	}
 }
 
+func TestOperationsApprox(t *testing.T) {
+	data := [][]float64{
+		{10, 20, 15, 30, 45, 50, 100, 30},
+		{5.5, 8, 2.2, 9.75, 8.45, 3, 2.5, 10.25, 4.75, 6.1, 7.67, 12.287, 5.47},
+		{-10, -20},
+		{102, 37, 44, 57, 67, 129},
+	}
+
+	tests := []struct {
+		name string
+		op   statsFunc
+		tol  float64
+		exp  []float64
+	}{
+		{"Variance", variance, 1e-12, []float64{725, 9.030963715976332, 25, 1067.5555555555557}},
+		{"Stddev", stddev, 1e-12, []float64{26.92582403567252, 3.00515618828312, 5, 32.67346867958092}},
+		{"Median", percentile(0.5), sketchAccuracy, []float64{30, 6.1, -10, 67}},
+		{"P90", percentile(0.9), sketchAccuracy, []float64{50, 10.25, -10, 129}},
+		{"P99", percentile(0.99), sketchAccuracy, []float64{100, 12.287, -10, 129}},
+	}
+
+	for _, tt := range tests {
+		for k, exp := range tt.exp {
+			name := fmt.Sprintf("%sData%d", tt.name, k)
+			t.Run(name, func(t *testing.T) {
+				res := tt.op(accumulate(data[k]))
+
+				if math.Abs(res-exp) > tt.tol*math.Abs(exp) {
+					t.Errorf("Expected %g, got %g instead", exp, res)
+				}
+			})
+		}
+	}
+}
+
+func TestSketchQuantile(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	s := newSketch()
+
+	data := make([]float64, 100000)
+	for i := range data {
+		data[i] = r.ExpFloat64() * 200
+		s.add(data[i])
+	}
+	sort.Float64s(data)
+
+	for _, q := range []float64{0, 0.25, 0.5, 0.9, 0.99, 0.999, 1} {
+		t.Run(fmt.Sprintf("Q%g", q), func(t *testing.T) {
+			exp := data[int(math.Round(q*float64(len(data)-1)))]
+			res := s.quantile(q)
+
+			if math.Abs(res-exp) > sketchAccuracy*exp {
+				t.Errorf("Expected %g within %g, got %g instead", exp, sketchAccuracy, res)
+			}
+		})
+	}
+
+	if n := len(s.pos); n > 2000 {
+		t.Errorf("Expected a bounded number of buckets, got %d", n)
+	}
+}
+
+func TestSketchMerge(t *testing.T) {
+	a, b, all := newSketch(), newSketch(), newSketch()
+
+	for i := 1; i <= 1000; i++ {
+		v := float64(i - 300)
+		if i%2 == 0 {
+			a.add(v)
+		} else {
+			b.add(v)
+		}
+		all.add(v)
+	}
+
+	a.merge(b)
+
+	for _, q := range []float64{0, 0.1, 0.5, 0.9, 1} {
+		if a.quantile(q) != all.quantile(q) {
+			t.Errorf("Expected merged q%g %g, got %g instead", q, all.quantile(q), a.quantile(q))
+		}
+	}
+}
+
+func TestParseOps(t *testing.T) {
+	names, funcs, err := parseOps("avg, p99,max")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(names) != 3 || len(funcs) != 3 || names[1] != "p99" {
+		t.Errorf("Expected 3 operations, got %q", names)
+	}
+
+	if _, _, err := parseOps("avg,invalid"); !errors.Is(err, ErrInvalidOperation) {
+		t.Errorf("Expected error %q, got %q instead", ErrInvalidOperation, err)
+	}
+}
+
+// readColumns collects the values readRows emits for cols, in row order.
+func readColumns(r io.Reader, cols []column, header bool) ([][]float64, error) {
+	data := make([][]float64, len(cols))
+
+	_, err := readRows(r, csvOptions{cols: cols, header: header}, func(_ string, vals []float64) {
+		for i, v := range vals {
+			data[i] = append(data[i], v)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
 func TestCSV2Float(t *testing.T) {
 	csvData := `
 	IP Address, Requests, Response Time
@@ -65,7 +187,7 @@ func TestCSV2Float(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			res, err := csv2float(tt.r, tt.col)
+			res, err := readColumns(tt.r, []column{{index: tt.col}}, true)
 			if tt.expErr != nil {
 				if err == nil {
 					t.Errorf("Expected error, got nil")
@@ -82,8 +204,67 @@ func TestCSV2Float(t *testing.T) {
 			}
 
 			for i, exp := range tt.exp {
-				if res[i] != exp {
-					t.Errorf("Expected %g, got %g instead", exp, res[i])
+				if res[0][i] != exp {
+					t.Errorf("Expected %g, got %g instead", exp, res[0][i])
+				}
+			}
+		})
+	}
+}
+
+func TestCSV2FloatColumns(t *testing.T) {
+	csvData := `IP Address,Requests,Response Time
+192.168.0.129,2056,236
+192.168.0.88,899,220
+192.168.0.199,3054,226`
+
+	noHeaderData := `192.168.0.129,2056,236
+192.168.0.88,899,220`
+
+	tests := []struct {
+		name   string
+		cols   string
+		header bool
+		data   string
+		exp    [][]float64
+		expErr error
+	}{
+		{name: "ByName", cols: "Response Time", header: true, data: csvData, exp: [][]float64{{236, 220, 226}}},
+		{name: "ByNameCaseInsensitive", cols: "requests", header: true, data: csvData, exp: [][]float64{{2056, 899, 3054}}},
+		{name: "Mixed", cols: "3, Requests", header: true, data: csvData, exp: [][]float64{{236, 220, 226}, {2056, 899, 3054}}},
+		{name: "NoHeader", cols: "2,3", header: false, data: noHeaderData, exp: [][]float64{{2056, 899}, {236, 220}}},
+		{name: "FailUnknownName", cols: "Latency", header: true, data: csvData, expErr: ErrInvalidColumn},
+		{name: "FailNameNoHeader", cols: "Requests", header: false, data: noHeaderData, expErr: ErrInvalidColumn},
+		{name: "FailZero", cols: "0", header: true, data: csvData, expErr: ErrInvalidColumn},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := func() ([][]float64, error) {
+				cols, err := parseColumns(tt.cols, !tt.header)
+				if err != nil {
+					return nil, err
+				}
+				return readColumns(bytes.NewBufferString(tt.data), cols, tt.header)
+			}()
+
+			if tt.expErr != nil {
+				if !errors.Is(err, tt.expErr) {
+					t.Errorf("Expected error %q, got %q instead", tt.expErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %q", err)
+			}
+
+			if len(res) != len(tt.exp) {
+				t.Fatalf("Expected %d columns, got %d instead", len(tt.exp), len(res))
+			}
+
+			for i := range tt.exp {
+				if fmt.Sprint(res[i]) != fmt.Sprint(tt.exp[i]) {
+					t.Errorf("Expected %v, got %v instead", tt.exp[i], res[i])
 				}
 			}
 		})
@@ -7,4 +7,9 @@ var (
 	ErrInvalidColumn    = errors.New("invalid column number")
 	ErrNoFiles          = errors.New("No input files")
 	ErrInvalidOperation = errors.New("Invalid operation")
+	ErrInvalidFormat    = errors.New("Invalid output format")
+	ErrInvalidFilter    = errors.New("Invalid filter")
+	ErrTooManyBadRows   = errors.New("Too many bad rows")
+	ErrInvalidBuckets   = errors.New("Invalid histogram buckets")
+	ErrInvalidTime      = errors.New("Invalid timestamp")
 )
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// filterOps lists the supported operators, longest first so that ">="
+// is matched before ">".
+var filterOps = []string{">=", "<=", "==", "!=", "^=", "$=", "*=", "!~", ">", "<", "=", "~"}
+
+// filter keeps only rows whose column matches a condition. Numeric
+// operators compare parsed values, the others compare strings.
+type filter struct {
+	col column
+	op  string
+	num float64
+	str string
+	re  *regexp.Regexp
+}
+
+// parseFilter parses an expression such as "Requests>1000" or
+// "IP Address^=192.168.0.1".
+func parseFilter(expr string, noHeader bool) (filter, error) {
+	pos := strings.IndexAny(expr, "<>=!~^$*")
+	if pos < 1 {
+		return filter{}, fmt.Errorf("%w: %q", ErrInvalidFilter, expr)
+	}
+
+	var f filter
+	for _, op := range filterOps {
+		if strings.HasPrefix(expr[pos:], op) {
+			f.op = op
+			break
+		}
+	}
+
+	if f.op == "" {
+		return filter{}, fmt.Errorf("%w: %q", ErrInvalidFilter, expr)
+	}
+
+	cols, err := parseColumns(expr[:pos], noHeader)
+	if err != nil {
+		return filter{}, err
+	}
+	f.col = cols[0]
+
+	f.str = strings.TrimSpace(expr[pos+len(f.op):])
+
+	switch f.op {
+	case ">=", "<=", "==", "!=", ">", "<":
+		if f.num, err = strconv.ParseFloat(f.str, 64); err != nil {
+			return filter{}, fmt.Errorf("%w: %q: %s", ErrInvalidFilter, expr, err)
+		}
+	case "~", "!~":
+		if f.re, err = regexp.Compile(f.str); err != nil {
+			return filter{}, fmt.Errorf("%w: %q: %s", ErrInvalidFilter, expr, err)
+		}
+	}
+
+	return f, nil
+}
+
+func parseFilters(exprs []string, noHeader bool) ([]filter, error) {
+	filters := make([]filter, 0, len(exprs))
+
+	for _, e := range exprs {
+		f, err := parseFilter(e, noHeader)
+		if err != nil {
+			return nil, err
+		}
+
+		filters = append(filters, f)
+	}
+
+	return filters, nil
+}
+
+func (f filter) match(v string) (bool, error) {
+	switch f.op {
+	case "=":
+		return v == f.str, nil
+	case "^=":
+		return strings.HasPrefix(v, f.str), nil
+	case "$=":
+		return strings.HasSuffix(v, f.str), nil
+	case "*=":
+		return strings.Contains(v, f.str), nil
+	case "~":
+		return f.re.MatchString(v), nil
+	case "!~":
+		return !f.re.MatchString(v), nil
+	}
+
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return false, fmt.Errorf("%w: %s", ErrNotNumber, err)
+	}
+
+	switch f.op {
+	case ">=":
+		return n >= f.num, nil
+	case "<=":
+		return n <= f.num, nil
+	case "==":
+		return n == f.num, nil
+	case "!=":
+		return n != f.num, nil
+	case ">":
+		return n > f.num, nil
+	}
+
+	return n < f.num, nil
+}
+
+// filterFlags collects repeated -where flags.
+type filterFlags []string
+
+func (f *filterFlags) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *filterFlags) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestFilterMatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		expr   string
+		value  string
+		exp    bool
+		expErr error
+	}{
+		{name: "Greater", expr: "Requests>1000", value: "2056", exp: true},
+		{name: "GreaterNoMatch", expr: "Requests>1000", value: "899", exp: false},
+		{name: "GreaterEqual", expr: "Requests >= 899", value: "899", exp: true},
+		{name: "Less", expr: "2<300", value: "236", exp: true},
+		{name: "LessEqual", expr: "2<=235", value: "236", exp: false},
+		{name: "NumEqual", expr: "2==236.0", value: "236", exp: true},
+		{name: "NumNotEqual", expr: "2!=236", value: "236", exp: false},
+		{name: "StrEqual", expr: "IP Address=192.168.0.88", value: "192.168.0.88", exp: true},
+		{name: "Prefix", expr: "IP Address^=192.168.0.1", value: "192.168.0.199", exp: true},
+		{name: "PrefixNoMatch", expr: "IP Address^=192.168.0.1", value: "192.168.0.88", exp: false},
+		{name: "Suffix", expr: "IP Address$=.88", value: "192.168.0.88", exp: true},
+		{name: "Contains", expr: "IP Address*=168.0", value: "192.168.0.88", exp: true},
+		{name: "Regex", expr: `IP Address~^192\.168\.0\.1\d\d$`, value: "192.168.0.129", exp: true},
+		{name: "NotRegex", expr: `IP Address!~^192\.168`, value: "192.168.0.129", exp: false},
+		{name: "FailNotNumber", expr: "Requests>1000", value: "abc", expErr: ErrNotNumber},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := parseFilter(tt.expr, false)
+			if err != nil {
+				t.Fatalf("Unexpected error: %q", err)
+			}
+
+			res, err := f.match(tt.value)
+			if tt.expErr != nil {
+				if !errors.Is(err, tt.expErr) {
+					t.Errorf("Expected error %q, got %q instead", tt.expErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %q", err)
+			}
+
+			if res != tt.exp {
+				t.Errorf("Expected %t, got %t instead", tt.exp, res)
+			}
+		})
+	}
+}
+
+func TestParseFilterFail(t *testing.T) {
+	tests := []struct {
+		name     string
+		expr     string
+		noHeader bool
+		expErr   error
+	}{
+		{"NoOperator", "Requests", false, ErrInvalidFilter},
+		{"NoColumn", ">1000", false, ErrInvalidFilter},
+		{"NotNumeric", "Requests>many", false, ErrInvalidFilter},
+		{"BadRegex", "IP Address~[", false, ErrInvalidFilter},
+		{"NameNoHeader", "Requests>10", true, ErrInvalidColumn},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseFilter(tt.expr, tt.noHeader)
+			if !errors.Is(err, tt.expErr) {
+				t.Errorf("Expected error %q, got %q instead", tt.expErr, err)
+			}
+		})
+	}
+}
+
+func TestReadRowsFilter(t *testing.T) {
+	csvData := `IP Address,Requests,Response Time
+192.168.0.129,2056,236
+192.168.0.88,899,220
+192.168.0.199,3054,226
+192.168.0.100,4133,218
+192.168.0.199,950,238`
+
+	filters, err := parseFilters([]string{"Requests>1000", "IP Address^=192.168.0.1"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []float64
+	opts := csvOptions{cols: []column{{name: "Response Time"}}, header: true, filters: filters}
+	_, err = readRows(bytes.NewBufferString(csvData), opts, func(_ string, vals []float64) {
+		got = append(got, vals[0])
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []float64{236, 226, 218}
+	if len(got) != len(exp) {
+		t.Fatalf("Expected %v, got %v instead", exp, got)
+	}
+
+	for i := range exp {
+		if got[i] != exp[i] {
+			t.Errorf("Expected %v, got %v instead", exp, got)
+		}
+	}
+}
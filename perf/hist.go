@@ -0,0 +1,421 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// chartWidth is the length of the longest bar in a histogram chart.
+const chartWidth = 40
+
+// histogram counts values in buckets. Bucket i holds the values in
+// [bounds[i], bounds[i+1]), and the last bucket also holds its upper
+// bound. Values outside the bounds are counted as under and over.
+type histogram struct {
+	bounds      []float64
+	counts      []uint64
+	under, over uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)-1),
+	}
+}
+
+func (h *histogram) add(v float64) {
+	h.addN(v, 1)
+}
+
+func (h *histogram) addN(v float64, n uint64) {
+	last := len(h.bounds) - 1
+
+	switch {
+	case v < h.bounds[0]:
+		h.under += n
+	case v > h.bounds[last]:
+		h.over += n
+	case v == h.bounds[last]:
+		h.counts[last-1] += n
+	default:
+		// The first bound greater than v closes its bucket.
+		i := sort.Search(len(h.bounds), func(i int) bool { return h.bounds[i] > v })
+		h.counts[i-1] += n
+	}
+}
+
+func (h *histogram) merge(o *histogram) {
+	for i, n := range o.counts {
+		h.counts[i] += n
+	}
+
+	h.under += o.under
+	h.over += o.over
+}
+
+// gridBins is the most fine bins a grid keeps.
+const gridBins = 4096
+
+// grid counts values in fine bins of equal width, a power of two, each
+// holding [i*width, (i+1)*width). When the bins in use would exceed
+// gridBins, the width doubles and neighbouring bins merge, so memory is
+// fixed whatever the number of values. Grids built from different files
+// merge exactly.
+type grid struct {
+	exp    int
+	bins   map[int64]uint64
+	lo, hi int64
+}
+
+func newGrid() *grid {
+	return &grid{bins: make(map[int64]uint64)}
+}
+
+func (g *grid) add(v float64) {
+	// Keep bin indexes well within int64: start at the finest width v
+	// can be told apart with, and never finer than needed for v.
+	_, e := math.Frexp(v)
+	if len(g.bins) == 0 {
+		g.exp = e - 52
+	} else if e-52 > g.exp {
+		g.coarsen(e - 52)
+	}
+
+	g.addBin(int64(math.Floor(math.Ldexp(v, -g.exp))), 1)
+	g.fit()
+}
+
+func (g *grid) addBin(i int64, n uint64) {
+	if len(g.bins) == 0 || i < g.lo {
+		g.lo = i
+	}
+	if len(g.bins) == 0 || i > g.hi {
+		g.hi = i
+	}
+
+	g.bins[i] += n
+}
+
+// coarsen widens the bins to 2^exp.
+func (g *grid) coarsen(exp int) {
+	if exp <= g.exp {
+		return
+	}
+
+	shift := uint(exp - g.exp)
+	bins := g.bins
+	g.bins = make(map[int64]uint64, len(bins))
+	g.exp = exp
+
+	for i, n := range bins {
+		// The arithmetic shift rounds negative indexes down too.
+		g.addBin(i>>shift, n)
+	}
+}
+
+// fit doubles the width until the bins in use span at most gridBins.
+func (g *grid) fit() {
+	for g.hi-g.lo >= gridBins {
+		g.coarsen(g.exp + 1)
+	}
+}
+
+func (g *grid) merge(o *grid) {
+	if len(o.bins) == 0 {
+		return
+	}
+
+	if len(g.bins) == 0 {
+		g.exp = o.exp
+	}
+	g.coarsen(o.exp)
+
+	shift := uint(g.exp - o.exp)
+	for i, n := range o.bins {
+		g.addBin(i>>shift, n)
+	}
+	g.fit()
+}
+
+// width returns the width of the bins.
+func (g *grid) width() float64 {
+	return math.Ldexp(1, g.exp)
+}
+
+// each calls fn with the lower edge and count of every bin in use.
+func (g *grid) each(fn func(v float64, n uint64)) {
+	for i, n := range g.bins {
+		fn(math.Ldexp(float64(i), g.exp), n)
+	}
+}
+
+// parseBounds parses explicit, comma separated bucket boundaries.
+func parseBounds(s string) ([]float64, error) {
+	var bounds []float64
+
+	for _, f := range strings.Split(s, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidBuckets, err)
+		}
+
+		if len(bounds) > 0 && v <= bounds[len(bounds)-1] {
+			return nil, fmt.Errorf("%w: boundaries must increase, got %v after %v", ErrInvalidBuckets, v, bounds[len(bounds)-1])
+		}
+
+		bounds = append(bounds, v)
+	}
+
+	if len(bounds) < 2 {
+		return nil, fmt.Errorf("%w: need at least 2 boundaries", ErrInvalidBuckets)
+	}
+
+	return bounds, nil
+}
+
+// histSpec returns the accumulator state for a histogram with explicit
+// bounds, or with n buckets derived from a grid once the range of the
+// values is known. Log scale buckets also need the sketch, for values too
+// wide apart for the grid.
+func histSpec(n int, bounds string, logScale bool) (accSpec, error) {
+	if bounds != "" {
+		b, err := parseBounds(bounds)
+		return accSpec{bounds: b}, err
+	}
+
+	if n < 1 {
+		return accSpec{}, fmt.Errorf("%w: bucket count %d", ErrInvalidBuckets, n)
+	}
+
+	return accSpec{grid: true, sketch: logScale}, nil
+}
+
+// spanBounds returns the boundaries of n buckets spanning [lo, hi], of
+// equal width or, with logScale, of equal ratio. Inner boundaries are
+// rounded to 4 significant digits when that keeps them increasing.
+func spanBounds(lo, hi float64, n int, logScale bool) ([]float64, error) {
+	if logScale && lo <= 0 {
+		return nil, fmt.Errorf("%w: log scale needs positive values, got %v", ErrInvalidBuckets, lo)
+	}
+
+	if lo == hi {
+		return []float64{lo, hi}, nil
+	}
+
+	bounds := make([]float64, n+1)
+	for i := range bounds {
+		f := float64(i) / float64(n)
+		if logScale {
+			bounds[i] = lo * math.Pow(hi/lo, f)
+		} else {
+			bounds[i] = lo + (hi-lo)*f
+		}
+	}
+	bounds[0], bounds[n] = lo, hi
+
+	rounded := make([]float64, len(bounds))
+	copy(rounded, bounds)
+	for i := 1; i < n; i++ {
+		rounded[i] = roundSignificant(bounds[i], 4)
+		if rounded[i] <= rounded[i-1] {
+			return bounds, nil
+		}
+	}
+
+	if rounded[n-1] >= hi {
+		return bounds, nil
+	}
+
+	return rounded, nil
+}
+
+func roundSignificant(v float64, digits int) float64 {
+	if v == 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0
+	}
+
+	scale := math.Pow(10, float64(digits)-math.Ceil(math.Log10(math.Abs(v))))
+	return math.Round(v*scale) / scale
+}
+
+// gridHistogram spreads the values of a over n buckets between the
+// smallest and largest value. Values are placed by the lower edge of
+// their grid bin, so a value can only end up in the wrong bucket when a
+// boundary falls inside its bin, less than a grid width away.
+//
+// With logScale, narrow buckets near a small minimum can be finer than
+// the grid; values then come from the sketch, within its relative
+// accuracy, instead.
+func gridHistogram(a *accumulator, n int, logScale bool) (*histogram, error) {
+	if a.count == 0 {
+		return &histogram{}, nil
+	}
+
+	bounds, err := spanBounds(a.min, a.max, n, logScale)
+	if err != nil {
+		return nil, err
+	}
+
+	each := a.grid.each
+	if logScale && a.grid.width() > sketchAccuracy*a.min {
+		each = a.sketch.each
+	}
+
+	// A bin's value may lie just outside the range of the values it
+	// holds.
+	h := newHistogram(bounds)
+	each(func(v float64, n uint64) {
+		h.addN(math.Max(a.min, math.Min(a.max, v)), n)
+	})
+
+	return h, nil
+}
+
+// histBucket is one printed bucket. Lower and upper are nil for the
+// buckets below and above the boundaries.
+type histBucket struct {
+	lower, upper *float64
+	closed       bool
+	count        uint64
+}
+
+func (b histBucket) String() string {
+	switch {
+	case b.lower == nil:
+		return "< " + formatFloat(*b.upper)
+	case b.upper == nil:
+		return "> " + formatFloat(*b.lower)
+	case b.closed:
+		return fmt.Sprintf("[%s, %s]", formatFloat(*b.lower), formatFloat(*b.upper))
+	}
+
+	return fmt.Sprintf("[%s, %s)", formatFloat(*b.lower), formatFloat(*b.upper))
+}
+
+// buckets lists the buckets of h, with the under and over buckets only
+// when they are not empty.
+func (h *histogram) buckets() []histBucket {
+	var res []histBucket
+
+	if h.under > 0 {
+		res = append(res, histBucket{upper: &h.bounds[0], count: h.under})
+	}
+
+	for i, n := range h.counts {
+		res = append(res, histBucket{
+			lower:  &h.bounds[i],
+			upper:  &h.bounds[i+1],
+			closed: i == len(h.counts)-1,
+			count:  n,
+		})
+	}
+
+	if h.over > 0 {
+		res = append(res, histBucket{lower: &h.bounds[len(h.bounds)-1], count: h.over})
+	}
+
+	return res
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// printHistograms prints a histogram per column as an ASCII bar chart,
+// or as one row per bucket in CSV or JSON.
+func printHistograms(out io.Writer, format string, cols []column, hists []*histogram) error {
+	switch format {
+	case "csv":
+		w := csv.NewWriter(out)
+		if err := w.Write([]string{"column", "lower", "upper", "count"}); err != nil {
+			return err
+		}
+
+		for i, h := range hists {
+			for _, b := range h.buckets() {
+				rec := []string{cols[i].String(), "", "", strconv.FormatUint(b.count, 10)}
+				if b.lower != nil {
+					rec[1] = formatFloat(*b.lower)
+				}
+				if b.upper != nil {
+					rec[2] = formatFloat(*b.upper)
+				}
+
+				if err := w.Write(rec); err != nil {
+					return err
+				}
+			}
+		}
+
+		w.Flush()
+		return w.Error()
+	case "json":
+		res := []map[string]interface{}{}
+		for i, h := range hists {
+			for _, b := range h.buckets() {
+				r := map[string]interface{}{"column": cols[i].String(), "count": b.count}
+				if b.lower != nil {
+					r["lower"] = *b.lower
+				}
+				if b.upper != nil {
+					r["upper"] = *b.upper
+				}
+				res = append(res, r)
+			}
+		}
+
+		return json.NewEncoder(out).Encode(res)
+	}
+
+	for i, h := range hists {
+		if len(hists) > 1 {
+			if i > 0 {
+				fmt.Fprintln(out)
+			}
+			fmt.Fprintf(out, "%s:\n", cols[i])
+		}
+
+		if err := printChart(out, h.buckets()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// printChart prints one line per bucket with a bar scaled to the largest
+// count.
+func printChart(out io.Writer, buckets []histBucket) error {
+	labelWidth, maxCount := 0, uint64(0)
+	for _, b := range buckets {
+		if l := len(b.String()); l > labelWidth {
+			labelWidth = l
+		}
+		if b.count > maxCount {
+			maxCount = b.count
+		}
+	}
+
+	for _, b := range buckets {
+		bar := 0
+		if maxCount > 0 {
+			bar = int(math.Round(float64(b.count) / float64(maxCount) * chartWidth))
+		}
+		if bar == 0 && b.count > 0 {
+			bar = 1
+		}
+
+		if _, err := fmt.Fprintf(out, "%-*s %-*s %d\n", labelWidth, b, chartWidth, strings.Repeat("#", bar), b.count); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
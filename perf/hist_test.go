@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestHistogramAdd(t *testing.T) {
+	h := newHistogram([]float64{10, 20, 30})
+	for _, v := range []float64{5, 10, 15, 20, 29.9, 30, 31} {
+		h.add(v)
+	}
+
+	o := newHistogram([]float64{10, 20, 30})
+	o.add(12)
+	h.merge(o)
+
+	exp := "under 1 counts [3 3] over 1"
+	res := fmt.Sprintf("under %d counts %v over %d", h.under, h.counts, h.over)
+	if res != exp {
+		t.Errorf("Expected %q, got %q instead", exp, res)
+	}
+}
+
+func TestGrid(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     []float64
+		expWidth float64
+		expBins  int
+	}{
+		{name: "Exact", a: []float64{218, 238, 225}, b: []float64{219.5}, expWidth: 0.5, expBins: 4},
+		{name: "Negative", a: []float64{-3, -1}, b: []float64{2}, expBins: 3},
+		{name: "Coarsen", a: []float64{0, 1, 10000}, expWidth: 4, expBins: 2},
+		{name: "CoarsenMerge", a: []float64{0.5}, b: []float64{0, 10000}, expWidth: 4, expBins: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, o := newGrid(), newGrid()
+			for _, v := range tt.a {
+				g.add(v)
+			}
+			for _, v := range tt.b {
+				o.add(v)
+			}
+			g.merge(o)
+
+			var n uint64
+			g.each(func(v float64, c uint64) { n += c })
+			if exp := uint64(len(tt.a) + len(tt.b)); n != exp {
+				t.Errorf("Expected %d values, got %d instead", exp, n)
+			}
+
+			if len(g.bins) != tt.expBins {
+				t.Errorf("Expected %d bins, got %d instead", tt.expBins, len(g.bins))
+			}
+
+			if g.hi-g.lo >= gridBins {
+				t.Errorf("Expected at most %d bins spanned, got %d instead", gridBins, g.hi-g.lo+1)
+			}
+
+			if tt.expWidth != 0 && g.width() > tt.expWidth {
+				t.Errorf("Expected width at most %g, got %g instead", tt.expWidth, g.width())
+			}
+		})
+	}
+}
+
+func TestSpanBounds(t *testing.T) {
+	tests := []struct {
+		name     string
+		lo, hi   float64
+		n        int
+		logScale bool
+		exp      string
+		expErr   error
+	}{
+		{name: "Linear", lo: 0, hi: 100, n: 4, exp: "[0 25 50 75 100]"},
+		{name: "Log", lo: 1, hi: 1000, n: 3, logScale: true, exp: "[1 10 100 1000]"},
+		{name: "Rounded", lo: 218, hi: 238, n: 3, exp: "[218 224.7 231.3 238]"},
+		{name: "SingleValue", lo: 5, hi: 5, n: 10, exp: "[5 5]"},
+		{name: "FailLogZero", lo: 0, hi: 10, n: 3, logScale: true, expErr: ErrInvalidBuckets},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bounds, err := spanBounds(tt.lo, tt.hi, tt.n, tt.logScale)
+			if tt.expErr != nil {
+				if !errors.Is(err, tt.expErr) {
+					t.Errorf("Expected error %q, got %q instead", tt.expErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %q", err)
+			}
+
+			if res := fmt.Sprint(bounds); res != tt.exp {
+				t.Errorf("Expected %q, got %q instead", tt.exp, res)
+			}
+		})
+	}
+}
+
+func TestParseBoundsFail(t *testing.T) {
+	for _, s := range []string{"", "10", "10,x", "10,10", "20,10"} {
+		t.Run(s, func(t *testing.T) {
+			if _, err := parseBounds(s); !errors.Is(err, ErrInvalidBuckets) {
+				t.Errorf("Expected error %q, got %q instead", ErrInvalidBuckets, err)
+			}
+		})
+	}
+}
+
+func TestRunHist(t *testing.T) {
+	files := []string{"./testdata/example.csv", "./testdata/example2.csv"}
+
+	tests := []struct {
+		name   string
+		cfg    config
+		exp    string
+		expErr error
+	}{
+		{name: "Chart", cfg: config{col: "Response Time", hist: true, buckets: 2},
+			exp: "[218, 228) #############                            6\n" +
+				"[228, 238] ######################################## 19\n"},
+		{name: "ChartColumns", cfg: config{col: "3,Bytes", hist: true, bounds: "3000,3500"},
+			exp: "col 3:\n" +
+				"< 3000       ######################################## 25\n" +
+				"[3000, 3500]                                          0\n" +
+				"\n" +
+				"Bytes:\n" +
+				"[3000, 3500] ###################                      8\n" +
+				"> 3500       ######################################## 17\n"},
+		{name: "BoundsCSV", cfg: config{col: "3", hist: true, bounds: "220,230", format: "csv"},
+			exp: "column,lower,upper,count\n" +
+				"col 3,,220,2\n" +
+				"col 3,220,230,4\n" +
+				"col 3,230,,19\n"},
+		{name: "LogJSON", cfg: config{col: "3", hist: true, buckets: 2, logScale: true, format: "json"},
+			exp: `[{"column":"col 3","count":6,"lower":218,"upper":227.8},` +
+				`{"column":"col 3","count":19,"lower":227.8,"upper":238}]` + "\n"},
+		{name: "FailBuckets", cfg: config{col: "3", hist: true, buckets: 0}, expErr: ErrInvalidBuckets},
+		{name: "FailBounds", cfg: config{col: "3", hist: true, bounds: "300,200"}, expErr: ErrInvalidBuckets},
+		{name: "NoValues", cfg: config{col: "3", hist: true, buckets: 2, logScale: true, where: []string{"3>1000"}}, exp: ""},
+		{name: "FailGroup", cfg: config{col: "3", hist: true, buckets: 2, group: "1"}, expErr: ErrInvalidFormat},
+		{name: "FailFormat", cfg: config{col: "3", hist: true, buckets: 2, format: "xml"}, expErr: ErrInvalidFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var res bytes.Buffer
+
+			tt.cfg.op = "sum"
+			err := run(context.Background(), files, tt.cfg, &res)
+			if tt.expErr != nil {
+				if !errors.Is(err, tt.expErr) {
+					t.Errorf("Expected error %q, got %q instead", tt.expErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %q", err)
+			}
+
+			if res.String() != tt.exp {
+				t.Errorf("Expected %q, got %q instead", tt.exp, res.String())
+			}
+		})
+	}
+}
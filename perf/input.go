@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// decompress returns a reader that transparently decompresses gzip input,
+// detected by its magic number.
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+
+	magic, err := br.Peek(2)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("cannot read data from file: %w", err)
+	}
+
+	if !bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		return br, nil
+	}
+
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read data from file: %w", err)
+	}
+
+	return zr, nil
+}
+
+// readJSONL reads one JSON object per line from r. Each column is a dot
+// separated field path; the selected fields are passed to process as a
+// row of strings in the order of cols. Errors in lines are passed to bad
+// with their line number.
+func readJSONL(r io.Reader, cols []column,
+	process func(row []string, idx []int) error, bad func(line int, err error) error) error {
+
+	paths := make([][]string, len(cols))
+	idx := make([]int, len(cols))
+	for i, c := range cols {
+		if c.name == "" {
+			return fmt.Errorf("%w: JSON lines need field paths, got %s", ErrInvalidColumn, c)
+		}
+
+		paths[i] = strings.Split(c.name, ".")
+		idx[i] = i
+	}
+
+	row := make([]string, len(cols))
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for line := 1; s.Scan(); line++ {
+		if len(bytes.TrimSpace(s.Bytes())) == 0 {
+			continue
+		}
+
+		err := jsonRow(s.Bytes(), paths, row)
+		if err == nil {
+			err = process(row, idx)
+		}
+
+		if err != nil {
+			if err := bad(line, fmt.Errorf("line %d: %w", line, err)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return fmt.Errorf("cannot read data from file: %w", err)
+	}
+
+	return nil
+}
+
+// jsonRow decodes a JSON object and fills row with the fields at paths.
+func jsonRow(data []byte, paths [][]string, row []string) error {
+	var obj map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(&obj); err != nil {
+		return fmt.Errorf("cannot read data from file: %w", err)
+	}
+
+	for i, p := range paths {
+		v, err := lookupField(obj, p)
+		if err != nil {
+			return err
+		}
+
+		row[i] = v
+	}
+
+	return nil
+}
+
+// lookupField follows path through nested objects and returns the value
+// as a string.
+func lookupField(obj map[string]interface{}, path []string) (string, error) {
+	var v interface{} = obj
+
+	for _, p := range path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("%w: no field %q", ErrInvalidColumn, strings.Join(path, "."))
+		}
+
+		if v, ok = m[p]; !ok {
+			return "", fmt.Errorf("%w: no field %q", ErrInvalidColumn, strings.Join(path, "."))
+		}
+	}
+
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case nil:
+		return "", nil
+	}
+
+	return "", fmt.Errorf("%w: field %q is not a value", ErrInvalidColumn, strings.Join(path, "."))
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestDecompress(t *testing.T) {
+	data := "IP Address,Requests\n192.168.0.1,10\n"
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	if _, err := zw.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		r    io.Reader
+	}{
+		{"Plain", bytes.NewBufferString(data)},
+		{"Gzip", &gz},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := decompress(tt.r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(res) != data {
+				t.Errorf("Expected %q, got %q instead", data, res)
+			}
+		})
+	}
+}
+
+func TestReadRowsJSONL(t *testing.T) {
+	jsonData := `{"ip": "192.168.0.129", "request": {"count": 2056, "latency": 236}}
+{"ip": "192.168.0.88", "request": {"count": 899, "latency": 220}}
+
+{"ip": "192.168.0.199", "request": {"count": 3054, "latency": "226"}}`
+
+	tests := []struct {
+		name    string
+		cols    []column
+		group   *column
+		filters []string
+		data    string
+		exp     string
+		expErr  error
+	}{
+		{name: "FieldPath", cols: []column{{name: "request.latency"}}, data: jsonData,
+			exp: ":[236] :[220] :[226] "},
+		{name: "MultiField", cols: []column{{name: "request.count"}, {name: "request.latency"}}, data: jsonData,
+			exp: ":[2056 236] :[899 220] :[3054 226] "},
+		{name: "GroupFilter", cols: []column{{name: "request.latency"}}, group: &column{name: "ip"},
+			filters: []string{"request.count>1000"}, data: jsonData,
+			exp: "192.168.0.129:[236] 192.168.0.199:[226] "},
+		{name: "FailMissingField", cols: []column{{name: "request.bytes"}}, data: jsonData, expErr: ErrInvalidColumn},
+		{name: "FailIndexColumn", cols: []column{{index: 2}}, data: jsonData, expErr: ErrInvalidColumn},
+		{name: "FailNotNumber", cols: []column{{name: "ip"}}, data: jsonData, expErr: ErrNotNumber},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filters, err := parseFilters(tt.filters, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var res bytes.Buffer
+			opts := csvOptions{cols: tt.cols, group: tt.group, filters: filters, jsonl: true}
+			_, err = readRows(bytes.NewBufferString(tt.data), opts, func(key string, vals []float64) {
+				fmt.Fprintf(&res, "%s:%v ", key, vals)
+			})
+
+			if tt.expErr != nil {
+				if !errors.Is(err, tt.expErr) {
+					t.Errorf("Expected error %q, got %q instead", tt.expErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %q", err)
+			}
+
+			if res.String() != tt.exp {
+				t.Errorf("Expected %q, got %q instead", tt.exp, res.String())
+			}
+		})
+	}
+}
+
+func TestReadRowsDialect(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   csvOptions
+		data   string
+		exp    string
+		expErr bool
+	}{
+		{name: "Semicolon", opts: csvOptions{comma: ';'}, data: "IP;Time\na;1\nb;2\n", exp: "[1] [2] "},
+		{name: "Tab", opts: csvOptions{comma: '\t'}, data: "IP\tTime\na\t1\nb\t2\n", exp: "[1] [2] "},
+		{name: "Comment", opts: csvOptions{comment: '#'}, data: "IP,Time\n# a comment\na,1\n", exp: "[1] "},
+		{name: "TrimSpace", opts: csvOptions{trimLeadingSpace: true}, data: "IP, Time\na,  1\nb, 2\n", exp: "[1] [2] "},
+		{name: "LazyQuotes", opts: csvOptions{lazyQuotes: true}, data: "IP,Time\na \"x\" b,1\n", exp: "[1] "},
+		{name: "FailStrictQuotes", opts: csvOptions{}, data: "IP,Time\na \"x\" b,1\n", expErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var res bytes.Buffer
+
+			tt.opts.cols = []column{{name: "Time"}}
+			tt.opts.header = true
+			_, err := readRows(bytes.NewBufferString(tt.data), tt.opts, func(_ string, vals []float64) {
+				fmt.Fprintf(&res, "%v ", vals)
+			})
+
+			if tt.expErr {
+				if err == nil {
+					t.Error("Expected error, got nil instead")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %q", err)
+			}
+
+			if res.String() != tt.exp {
+				t.Errorf("Expected %q, got %q instead", tt.exp, res.String())
+			}
+		})
+	}
+}
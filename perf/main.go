@@ -1,102 +1,579 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
 	"runtime"
+	"sort"
+	"strconv"
 	"sync"
 )
 
-var opFunc statsFunc
+type config struct {
+	op       string
+	col      string
+	noHeader bool
+	group    string
+	format   string
+	where    []string
+
+	precision string
+	perFile   bool
+
+	timeCol    string
+	timeLayout string
+	window     string
+
+	base  string
+	alpha float64
+
+	hist     bool
+	buckets  int
+	bounds   string
+	logScale bool
+
+	input      string
+	delim      string
+	comment    string
+	lazyQuotes bool
+	trimSpace  bool
+	stdin      io.Reader
+
+	skipBad      bool
+	maxBad       string
+	emptyMissing bool
+	errOut       io.Writer
+}
 
 func main() {
-	op := flag.String("op", "sum", "Operation to be executed")
-	column := flag.Int("col", 1, "CSV column on which to execute operation")
+	op := flag.String("op", "sum", "Comma separated operations: sum, avg, min, max, count, median, p50, p90, p99, stddev, variance, mode")
+	column := flag.String("col", "1", "Comma separated CSV columns, by 1-based number or header name")
+	noHeader := flag.Bool("no-header", false, "Files have no header row")
+	group := flag.String("group", "", "Column to group results by, by 1-based number or header name")
+	format := flag.String("format", "", "Output format: plain, csv, json or table (default plain, csv for grouped results); for histograms: chart (default), csv or json")
+	precision := flag.String("precision", "", "Round values to this many decimals")
+	perFile := flag.Bool("per-file", false, "Print a row for each file before the total")
+	timeCol := flag.String("time", "", "Timestamp column for -window, by 1-based number or header name")
+	timeLayout := flag.String("time-layout", "unix", "Timestamp layout: unix, unixms, rfc3339 or a Go layout such as '2006-01-02 15:04:05'")
+	window := flag.String("window", "", "Aggregate rows into time windows of this size, such as 1m or 1h, ordered by time")
+	base := flag.String("base", "", "Comma separated baseline files or glob patterns to compare the files given as arguments against")
+	alpha := flag.Float64("alpha", defaultAlpha, "Significance level for -base comparisons. Only avg, median and percentiles are tested, other operations show no p-value")
+	var where filterFlags
+	flag.Var(&where, "where", "Row filter such as 'Requests>1000' or 'IP Address^=192.168.0.1'. Operators: > >= < <= == != (numeric), = ^= $= *= ~ !~ (string). Repeat to combine")
+
+	input := flag.String("input", "csv", "Input format: csv or jsonl. With jsonl, columns are field paths such as 'request.latency'")
+	delim := flag.String("delim", ",", "CSV field delimiter, \\t for tab")
+	tsv := flag.Bool("tsv", false, "Tab separated input, same as -delim '\\t'")
+	comment := flag.String("comment", "", "Ignore CSV lines starting with this character")
+	lazyQuotes := flag.Bool("lazy-quotes", false, "Allow quotes in unquoted CSV fields and non-doubled quotes in quoted fields")
+	trimSpace := flag.Bool("trim-space", false, "Ignore leading white space in CSV fields")
+
+	hist := flag.Bool("hist", false, "Print a histogram of each column instead of operations")
+	buckets := flag.Int("buckets", 10, "Number of histogram buckets between the smallest and largest value")
+	bounds := flag.String("bounds", "", "Comma separated histogram bucket boundaries, instead of -buckets")
+	logScale := flag.Bool("log", false, "With -buckets, size histogram buckets on a log scale")
+
+	skipBad := flag.Bool("skip-bad", false, "Skip rows that cannot be parsed and report them on stderr")
+	maxBad := flag.String("max-bad", "", "With -skip-bad, fail a file with more skipped rows than this count or percentage such as 5%")
+	emptyMissing := flag.Bool("empty-missing", false, "Treat empty cells as missing values instead of bad rows")
 
 	flag.Parse()
 
-	if err := run(flag.Args(), *op, *column, os.Stdout); err != nil {
+	if *tsv {
+		*delim = "\t"
+	}
+
+	// Read from stdin when no files are given and it is not a terminal.
+	var stdin io.Reader
+	if fi, err := os.Stdin.Stat(); err == nil && fi.Mode()&os.ModeCharDevice == 0 {
+		stdin = os.Stdin
+	}
+
+	c := config{
+		op:       *op,
+		col:      *column,
+		noHeader: *noHeader,
+		group:    *group,
+		format:   *format,
+		where:    where,
+
+		precision: *precision,
+		perFile:   *perFile,
+
+		timeCol:    *timeCol,
+		timeLayout: *timeLayout,
+		window:     *window,
+
+		base:  *base,
+		alpha: *alpha,
+
+		hist:     *hist,
+		buckets:  *buckets,
+		bounds:   *bounds,
+		logScale: *logScale,
+
+		input:      *input,
+		delim:      *delim,
+		comment:    *comment,
+		lazyQuotes: *lazyQuotes,
+		trimSpace:  *trimSpace,
+		stdin:      stdin,
+
+		skipBad:      *skipBad,
+		maxBad:       *maxBad,
+		emptyMissing: *emptyMissing,
+		errOut:       os.Stderr,
+	}
+
+	// SIGINT cancels the run and stops the workers.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := run(ctx, flag.Args(), c, os.Stdout); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
 
-func run(filenames []string, op string, column int, out io.Writer) error {
-
+func run(ctx context.Context, filenames []string, cfg config, out io.Writer) error {
 	if len(filenames) == 0 {
-		return ErrNoFiles
+		if cfg.stdin == nil {
+			return ErrNoFiles
+		}
+		filenames = []string{"-"}
 	}
 
-	if column < 1 {
-		return fmt.Errorf("%w: %d", ErrInvalidColumn, column)
+	cols, err := parseColumns(cfg.col, cfg.noHeader)
+	if err != nil {
+		return err
 	}
 
-	switch op {
-	case "sum":
-		opFunc = sum
-	case "avg":
-		opFunc = avg
-	default:
-		return fmt.Errorf("%w: %s", ErrInvalidOperation, op)
+	names, opFuncs, err := parseOps(cfg.op)
+	if err != nil {
+		return err
+	}
+
+	filters, err := parseFilters(cfg.where, cfg.noHeader)
+	if err != nil {
+		return err
+	}
+
+	opts := csvOptions{cols: cols, header: !cfg.noHeader, filters: filters}
+	if err := setDialect(&opts, cfg); err != nil {
+		return err
+	}
+
+	opts.skipBad, opts.emptyMissing = cfg.skipBad, cfg.emptyMissing
+	if opts.maxBad, err = parseBadLimit(cfg.maxBad); err != nil {
+		return err
+	}
+
+	if cfg.group != "" {
+		g, err := parseColumns(cfg.group, cfg.noHeader)
+		if err != nil {
+			return err
+		}
+
+		if len(g) != 1 {
+			return fmt.Errorf("%w: group by a single column", ErrInvalidColumn)
+		}
+		opts.group = &g[0]
+	}
+
+	if cfg.window != "" {
+		if opts.group != nil {
+			return fmt.Errorf("%w: time windows cannot be grouped", ErrInvalidFormat)
+		}
+
+		t, err := parseColumns(cfg.timeCol, cfg.noHeader)
+		if err != nil {
+			return err
+		}
+
+		if len(t) != 1 {
+			return fmt.Errorf("%w: a single timestamp column is needed for windows", ErrInvalidColumn)
+		}
+
+		if opts.window, err = parseWindow(cfg.window, cfg.timeLayout); err != nil {
+			return err
+		}
+		opts.group = &t[0]
+	}
+
+	format, err := outputFormat(cfg, opts.group != nil)
+	if err != nil {
+		return err
+	}
+
+	prec := -1
+	if cfg.precision != "" {
+		if prec, err = strconv.Atoi(cfg.precision); err != nil || prec < 0 {
+			return fmt.Errorf("%w: precision %q", ErrInvalidFormat, cfg.precision)
+		}
+	}
+
+	spec := opsSpec(names)
+	if cfg.hist {
+		if spec, err = histSpec(cfg.buckets, cfg.bounds, cfg.logScale); err != nil {
+			return err
+		}
+	}
+
+	// Comparisons test for significance on the sketch buckets.
+	if cfg.base != "" {
+		spec.sketch = true
+	}
+
+	res := &results{cols: cols, ops: names}
+	switch {
+	case opts.window != nil:
+		res.keyName = "window"
+	case opts.group != nil:
+		res.keyName = opts.group.String()
+	}
+
+	// Values per file are computed before the file's accumulators are
+	// merged into the total.
+	var onFile func(fileResult)
+	perFile := make(map[string][]float64)
+	if cfg.perFile {
+		res.keyName = "file"
+		onFile = func(fr fileResult) {
+			accs, ok := fr.groups[""]
+			if !ok {
+				accs = newAccumulators(len(cols), spec)
+			}
+			perFile[fr.name] = values(accs, opFuncs)
+		}
+	}
+
+	consolidate, stats, err := readFiles(ctx, filenames, cfg.stdin, opts, spec, onFile)
+	if err != nil {
+		return err
+	}
+
+	if cfg.errOut != nil {
+		if err := printSkipped(cfg.errOut, filenames, stats); err != nil {
+			return err
+		}
+	}
+
+	if opts.group != nil {
+		keys := make([]string, 0, len(consolidate))
+		for k := range consolidate {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			res.add(k, values(consolidate[k], opFuncs))
+		}
+
+		return printResults(out, format, prec, res)
+	}
+
+	data, ok := consolidate[""]
+	if !ok {
+		data = newAccumulators(len(cols), spec)
+	}
+
+	if cfg.base != "" {
+		baseFiles, err := expandFiles(cfg.base)
+		if err != nil {
+			return err
+		}
+
+		base, baseStats, err := readFiles(ctx, baseFiles, cfg.stdin, opts, spec, nil)
+		if err != nil {
+			return err
+		}
+
+		if cfg.errOut != nil {
+			if err := printSkipped(cfg.errOut, baseFiles, baseStats); err != nil {
+				return err
+			}
+		}
+
+		baseData, ok := base[""]
+		if !ok {
+			baseData = newAccumulators(len(cols), spec)
+		}
+
+		alpha := cfg.alpha
+		if alpha <= 0 {
+			alpha = defaultAlpha
+		}
+
+		return printComparisons(out, format, prec, alpha, compare(cols, names, opFuncs, baseData, data))
+	}
+
+	if cfg.hist {
+		hists := make([]*histogram, len(cols))
+		for i, a := range data {
+			if hists[i] = a.hist; hists[i] != nil {
+				continue
+			}
+
+			if hists[i], err = gridHistogram(a, cfg.buckets, cfg.logScale); err != nil {
+				return err
+			}
+		}
+
+		return printHistograms(out, format, cols, hists)
+	}
+
+	if cfg.perFile {
+		for _, fname := range filenames {
+			res.add(fname, perFile[fname])
+		}
+	}
+	res.add("total", values(data, opFuncs))
+
+	return printResults(out, format, prec, res)
+}
+
+// outputFormat checks the output format against the kind of results and
+// returns it, or the default format for them.
+func outputFormat(cfg config, grouped bool) (string, error) {
+	switch {
+	case cfg.hist && (grouped || cfg.perFile):
+		return "", fmt.Errorf("%w: histograms cannot be grouped or split per file", ErrInvalidFormat)
+	case grouped && cfg.perFile:
+		return "", fmt.Errorf("%w: grouped results cannot be split per file", ErrInvalidFormat)
+	case cfg.base != "" && (cfg.hist || grouped || cfg.perFile):
+		return "", fmt.Errorf("%w: comparisons cannot be histograms, grouped or split per file", ErrInvalidFormat)
+	}
+
+	switch cfg.format {
+	case "":
+		if cfg.hist {
+			return "chart", nil
+		}
+		if grouped {
+			return "csv", nil
+		}
+		return "plain", nil
+	case "csv", "json":
+		return cfg.format, nil
+	case "chart":
+		if cfg.hist {
+			return cfg.format, nil
+		}
+	case "plain", "table":
+		if !cfg.hist {
+			return cfg.format, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: %s", ErrInvalidFormat, cfg.format)
+}
+
+// readFiles reads the files in parallel and merges their accumulators.
+// onFile, if not nil, sees the result of each file before it is merged.
+// The first error cancels the remaining workers, and so does cancelling
+// ctx. readFiles returns only after every goroutine it started has ended.
+func readFiles(ctx context.Context, filenames []string, stdin io.Reader, opts csvOptions,
+	spec accSpec, onFile func(fileResult)) (map[string][]*accumulator, map[string]rowStats, error) {
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		errOnce  sync.Once
+		firstErr error
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
 	}
 
-	resCh := make(chan []float64)
-	errCh := make(chan error)
 	filesCh := make(chan string)
-	doneCh := make(chan struct{})
+	resCh := make(chan fileResult)
 	wg := sync.WaitGroup{}
 
-	consolidate := make([]float64, 0)
-
 	go func() {
 		defer close(filesCh)
 		for _, fname := range filenames {
-			filesCh <- fname
+			select {
+			case filesCh <- fname:
+			case <-ctx.Done():
+				return
+			}
 		}
 	}()
 
-	for i := 0; i < runtime.NumCPU(); i++ {
+	workers := runtime.NumCPU()
+	if len(filenames) < workers {
+		workers = len(filenames)
+	}
+
+	for i := 0; i < workers; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 			for fname := range filesCh {
-
-				f, err := os.Open(fname)
+				res, err := readFile(ctx, fname, stdin, opts, spec)
 				if err != nil {
-					errCh <- fmt.Errorf("cannot open file: %w", err)
+					fail(err)
 					return
 				}
 
-				data, err := csv2float(f, column)
-				if err != nil {
-					errCh <- err
-				}
-
-				if err := f.Close(); err != nil {
-					errCh <- err
+				select {
+				case resCh <- res:
+				case <-ctx.Done():
+					return
 				}
-
-				resCh <- data
 			}
 		}()
 	}
 
 	go func() {
 		wg.Wait()
-		close(doneCh)
+		close(resCh)
 	}()
 
-	for {
-		select {
-		case err := <-errCh:
-			return err
-		case data := <-resCh:
-			consolidate = append(consolidate, data...)
-		case <-doneCh:
-			_, err := fmt.Fprintln(out, opFunc(consolidate))
-			return err
+	consolidate := make(map[string][]*accumulator)
+	stats := make(map[string]rowStats)
+
+	for res := range resCh {
+		stats[res.name] = res.stats
+		if onFile != nil {
+			onFile(res)
+		}
+
+		for key, accs := range res.groups {
+			if _, ok := consolidate[key]; !ok {
+				consolidate[key] = accs
+				continue
+			}
+
+			for i := range accs {
+				consolidate[key][i].merge(accs[i])
+			}
 		}
 	}
+
+	// resCh is closed after all workers are done, so firstErr is set.
+	if firstErr != nil {
+		return nil, nil, firstErr
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return consolidate, stats, nil
+}
+
+// readFile folds one input into accumulators. Reading stops when ctx is
+// cancelled.
+func readFile(ctx context.Context, fname string, stdin io.Reader, opts csvOptions, spec accSpec) (fileResult, error) {
+	f, err := openInput(fname, stdin)
+	if err != nil {
+		return fileResult{}, fmt.Errorf("cannot open file: %w", err)
+	}
+
+	data, st, err := csv2acc(ctxReader{ctx: ctx, r: f}, opts, spec)
+	if err != nil {
+		f.Close()
+		return fileResult{}, fmt.Errorf("%s: %w", fname, err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fileResult{}, err
+	}
+
+	return fileResult{name: fname, groups: data, stats: st}, nil
+}
+
+// ctxReader fails reads once its context is cancelled.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return r.r.Read(p)
+}
+
+// fileResult holds the accumulators and row counts read from one file.
+type fileResult struct {
+	name   string
+	groups map[string][]*accumulator
+	stats  rowStats
+}
+
+// setDialect applies the input format options of cfg to opts.
+func setDialect(opts *csvOptions, cfg config) error {
+	switch cfg.input {
+	case "", "csv":
+	case "jsonl":
+		opts.jsonl = true
+	default:
+		return fmt.Errorf("%w: input %s", ErrInvalidFormat, cfg.input)
+	}
+
+	if cfg.delim != "" {
+		r, err := singleRune(cfg.delim)
+		if err != nil {
+			return fmt.Errorf("%w: delimiter: %s", ErrInvalidFormat, err)
+		}
+		opts.comma = r
+	}
+
+	if cfg.comment != "" {
+		r, err := singleRune(cfg.comment)
+		if err != nil {
+			return fmt.Errorf("%w: comment: %s", ErrInvalidFormat, err)
+		}
+		opts.comment = r
+	}
+
+	if opts.comma == opts.comment && opts.comma != 0 {
+		return fmt.Errorf("%w: delimiter and comment are both %q", ErrInvalidFormat, opts.comma)
+	}
+
+	opts.lazyQuotes = cfg.lazyQuotes
+	opts.trimLeadingSpace = cfg.trimSpace
+
+	return nil
+}
+
+// singleRune accepts a single character, or the escapes \t and \s.
+func singleRune(s string) (rune, error) {
+	switch s {
+	case `\t`:
+		return '\t', nil
+	case `\s`:
+		return ' ', nil
+	}
+
+	r := []rune(s)
+	if len(r) != 1 || r[0] == '\r' || r[0] == '\n' || r[0] == '"' {
+		return 0, fmt.Errorf("invalid character %q", s)
+	}
+
+	return r[0], nil
+}
+
+// openInput opens the named file, or returns stdin for "-".
+func openInput(name string, stdin io.Reader) (io.ReadCloser, error) {
+	if name == "-" {
+		if stdin == nil {
+			return nil, ErrNoFiles
+		}
+		return io.NopCloser(stdin), nil
+	}
+
+	return os.Open(name)
 }
@@ -2,35 +2,46 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestRun(t *testing.T) {
 	tests := []struct {
 		name   string
-		col    int
+		col    string
 		op     string
 		exp    string
 		files  []string
 		expErr error
 	}{
-		{name: "RunAvg1File", col: 3, op: "avg", exp: "227.6\n", files: []string{"./testdata/example.csv"}, expErr: nil},
-		{name: "RunAvgMultiFiles", col: 3, op: "avg", exp: "233.84\n", files: []string{"./testdata/example.csv", "./testdata/example2.csv"}, expErr: nil},
-		{name: "RunFailRead", col: 2, op: "avg", exp: "", files: []string{"./testdata/example.csv", "./testdata/fakefile.csv"}, expErr: os.ErrNotExist},
-		{name: "RunFailColumn", col: 0, op: "avg", exp: "", files: []string{"./testdata/example.csv"}, expErr: ErrInvalidColumn},
-		{name: "RunFailNoFiles", col: 2, op: "avg", exp: "", files: []string{}, expErr: ErrNoFiles},
-		{name: "RunFailOperation", col: 2, op: "invalid", exp: "", files: []string{"./testdata/example.csv"}, expErr: ErrInvalidOperation},
+		{name: "RunAvg1File", col: "3", op: "avg", exp: "227.6\n", files: []string{"./testdata/example.csv"}, expErr: nil},
+		{name: "RunAvgMultiFiles", col: "3", op: "avg", exp: "233.84\n", files: []string{"./testdata/example.csv", "./testdata/example2.csv"}, expErr: nil},
+		{name: "RunMultiOps", col: "3", op: "avg,min,max,count", exp: "avg: 227.6\nmin: 218\nmax: 238\ncount: 5\n", files: []string{"./testdata/example.csv"}, expErr: nil},
+		{name: "RunColumnName", col: "Response Time", op: "avg", exp: "227.6\n", files: []string{"./testdata/example.csv"}, expErr: nil},
+		{name: "RunMultiColumns", col: "3,Bytes", op: "max", exp: "col 3: 238\nBytes: 3822\n", files: []string{"./testdata/example.csv"}, expErr: nil},
+		{name: "RunMultiColumnsOps", col: "3,Bytes", op: "min,max", exp: "col 3 min: 218\ncol 3 max: 238\nBytes min: 3200\nBytes max: 3822\n", files: []string{"./testdata/example.csv"}, expErr: nil},
+		{name: "RunFailColumnName", col: "Latency", op: "avg", exp: "", files: []string{"./testdata/example.csv"}, expErr: ErrInvalidColumn},
+		{name: "RunFailRead", col: "2", op: "avg", exp: "", files: []string{"./testdata/example.csv", "./testdata/fakefile.csv"}, expErr: os.ErrNotExist},
+		{name: "RunFailColumn", col: "0", op: "avg", exp: "", files: []string{"./testdata/example.csv"}, expErr: ErrInvalidColumn},
+		{name: "RunFailNoFiles", col: "2", op: "avg", exp: "", files: []string{}, expErr: ErrNoFiles},
+		{name: "RunFailOperation", col: "2", op: "invalid", exp: "", files: []string{"./testdata/example.csv"}, expErr: ErrInvalidOperation},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			var res bytes.Buffer
 
-			err := run(tt.files, tt.op, tt.col, &res)
+			err := run(context.Background(), tt.files, config{op: tt.op, col: tt.col}, &res)
 
 			if tt.expErr != nil {
 				if err == nil {
@@ -55,6 +66,199 @@ func TestRun(t *testing.T) {
 	}
 }
 
+func TestRunGroup(t *testing.T) {
+	files := []string{"./testdata/example.csv", "./testdata/example2.csv"}
+
+	tests := []struct {
+		name   string
+		cfg    config
+		exp    string
+		expErr error
+	}{
+		{name: "GroupCSV", cfg: config{op: "count,max", col: "Response Time", group: "IP Address"},
+			exp: "IP Address,Response Time count,Response Time max\n" +
+				"192.160.0.199,1,238\n" +
+				"192.168.0.100,2,218\n" +
+				"192.168.0.199,20,238\n" +
+				"192.168.0.88,2,220\n"},
+		{name: "GroupByIndexJSON", cfg: config{op: "min", col: "3", group: "1", format: "json"},
+			exp: `[{"col 1":"192.160.0.199","col 3 min":238},` +
+				`{"col 1":"192.168.0.100","col 3 min":218},` +
+				`{"col 1":"192.168.0.199","col 3 min":226},` +
+				`{"col 1":"192.168.0.88","col 3 min":220}]` + "\n"},
+		{name: "GroupFiltered", cfg: config{op: "count", col: "Response Time", group: "IP Address", where: []string{"Response Time<238", "IP Address~\\.1\\d\\d$"}},
+			exp: "IP Address,Response Time count\n" +
+				"192.168.0.100,2\n" +
+				"192.168.0.199,4\n"},
+		{name: "GroupFailColumn", cfg: config{op: "sum", col: "3", group: "Host"}, expErr: ErrInvalidColumn},
+		{name: "GroupFailFormat", cfg: config{op: "sum", col: "3", group: "1", format: "xml"}, expErr: ErrInvalidFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var res bytes.Buffer
+
+			err := run(context.Background(), files, tt.cfg, &res)
+			if tt.expErr != nil {
+				if !errors.Is(err, tt.expErr) {
+					t.Errorf("Expected error %q, got %q instead", tt.expErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %q", err)
+			}
+
+			if res.String() != tt.exp {
+				t.Errorf("Expected %q, got %q instead", tt.exp, res.String())
+			}
+		})
+	}
+}
+
+func TestRunInput(t *testing.T) {
+	example, err := os.ReadFile("./testdata/example.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gzFile := filepath.Join(t.TempDir(), "example.csv.gz")
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(example)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(gzFile, gz.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tsv := strings.ReplaceAll(string(example), ",", "\t")
+	jsonl := `{"ip": "192.168.0.199", "time": 236}
+{"ip": "192.168.0.88", "time": 220}`
+
+	tests := []struct {
+		name   string
+		files  []string
+		cfg    config
+		exp    string
+		expErr error
+	}{
+		{name: "Stdin", cfg: config{op: "avg", col: "3", stdin: bytes.NewReader(example)}, exp: "227.6\n"},
+		{name: "StdinDash", files: []string{"-", "./testdata/example.csv"}, cfg: config{op: "count", col: "3", stdin: bytes.NewReader(example)}, exp: "10\n"},
+		{name: "Gzip", files: []string{gzFile, "./testdata/example.csv"}, cfg: config{op: "avg", col: "3"}, exp: "227.6\n"},
+		{name: "TSV", cfg: config{op: "avg", col: "Response Time", delim: "\\t", stdin: strings.NewReader(tsv)}, exp: "227.6\n"},
+		{name: "JSONL", cfg: config{op: "max", col: "time", input: "jsonl", stdin: strings.NewReader(jsonl)}, exp: "236\n"},
+		{name: "FailNoStdin", cfg: config{op: "avg", col: "3"}, expErr: ErrNoFiles},
+		{name: "FailDelim", files: []string{"./testdata/example.csv"}, cfg: config{op: "avg", col: "3", delim: ";;"}, expErr: ErrInvalidFormat},
+		{name: "FailInput", files: []string{"./testdata/example.csv"}, cfg: config{op: "avg", col: "3", input: "xml"}, expErr: ErrInvalidFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var res bytes.Buffer
+
+			err := run(context.Background(), tt.files, tt.cfg, &res)
+			if tt.expErr != nil {
+				if !errors.Is(err, tt.expErr) {
+					t.Errorf("Expected error %q, got %q instead", tt.expErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %q", err)
+			}
+
+			if res.String() != tt.exp {
+				t.Errorf("Expected %q, got %q instead", tt.exp, res.String())
+			}
+		})
+	}
+}
+
+// checkGoroutines fails the test if goroutines started during the test
+// are still running once it ends.
+func checkGoroutines(t *testing.T) {
+	t.Helper()
+
+	before := runtime.NumGoroutine()
+	t.Cleanup(func() {
+		n := runtime.NumGoroutine()
+		for i := 0; i < 100 && n > before; i++ {
+			time.Sleep(10 * time.Millisecond)
+			n = runtime.NumGoroutine()
+		}
+
+		if n > before {
+			t.Errorf("Expected %d goroutines, got %d instead", before, n)
+		}
+	})
+}
+
+// endlessCSV is an input that never ends.
+type endlessCSV struct{}
+
+func (endlessCSV) Read(p []byte) (int, error) {
+	row := "192.168.0.1,100,220\n"
+	n := 0
+	for n+len(row) <= len(p) {
+		n += copy(p[n:], row)
+	}
+
+	return n, nil
+}
+
+func TestRunCancel(t *testing.T) {
+	badFile := filepath.Join(t.TempDir(), "bad.csv")
+	if err := os.WriteFile(badFile, []byte("IP Address,Requests\n192.168.0.1,n/a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var many []string
+	for i := 0; i < 4*runtime.NumCPU(); i++ {
+		many = append(many, "./testdata/example.csv")
+	}
+
+	tests := []struct {
+		name    string
+		files   []string
+		stdin   io.Reader
+		timeout time.Duration
+		expErr  error
+	}{
+		{name: "FirstErrorNotNumber", files: append([]string{badFile}, many...), expErr: ErrNotNumber},
+		{name: "FirstErrorMissingFile", files: append(append([]string{}, many...), "./testdata/fakefile.csv"), expErr: os.ErrNotExist},
+		{name: "ErrorWithEndlessInput", files: []string{badFile, "-"}, stdin: endlessCSV{}, expErr: ErrNotNumber},
+		{name: "Cancelled", files: many, timeout: -1, expErr: context.Canceled},
+		{name: "CancelEndlessInput", files: []string{"-"}, stdin: endlessCSV{}, timeout: 50 * time.Millisecond, expErr: context.Canceled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkGoroutines(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			switch {
+			case tt.timeout < 0:
+				cancel()
+			case tt.timeout > 0:
+				time.AfterFunc(tt.timeout, cancel)
+			}
+
+			cfg := config{op: "avg", col: "2", stdin: tt.stdin}
+
+			err := run(ctx, tt.files, cfg, io.Discard)
+			if !errors.Is(err, tt.expErr) {
+				t.Errorf("Expected error %q, got %q instead", tt.expErr, err)
+			}
+		})
+	}
+}
+
 func BenchmarkRun(b *testing.B) {
 	filenames, err := filepath.Glob("./testdata/benchmark/*.csv")
 	if err != nil {
@@ -64,8 +268,62 @@ func BenchmarkRun(b *testing.B) {
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
-		if err := run(filenames, "avg", 2, io.Discard); err != nil {
+		if err := run(context.Background(), filenames, config{op: "avg", col: "2"}, io.Discard); err != nil {
 			b.Error(err)
 		}
 	}
 }
+
+// BenchmarkRunMemory reports the peak heap in use during a run. Workers
+// send back accumulators instead of values, so the peak heap stays flat as
+// the number of files grows.
+func BenchmarkRunMemory(b *testing.B) {
+	filenames, err := filepath.Glob("./testdata/benchmark/*.csv")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, n := range []int{10, 100, len(filenames)} {
+		for _, op := range []string{"avg", "avg,p99,max"} {
+			b.Run(fmt.Sprintf("Files%d/%s", n, op), func(b *testing.B) {
+				b.ReportAllocs()
+
+				var peak uint64
+				for i := 0; i < b.N; i++ {
+					runtime.GC()
+
+					done := make(chan struct{})
+					sampled := make(chan uint64)
+					go func() {
+						var max uint64
+						var m runtime.MemStats
+						for {
+							runtime.ReadMemStats(&m)
+							if m.HeapInuse > max {
+								max = m.HeapInuse
+							}
+
+							select {
+							case <-done:
+								sampled <- max
+								return
+							case <-time.After(time.Millisecond):
+							}
+						}
+					}()
+
+					if err := run(context.Background(), filenames[:n], config{op: op, col: "2"}, io.Discard); err != nil {
+						b.Error(err)
+					}
+
+					close(done)
+					if m := <-sampled; m > peak {
+						peak = m
+					}
+				}
+
+				b.ReportMetric(float64(peak), "peak-heap-B")
+			})
+		}
+	}
+}
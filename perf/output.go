@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// results holds one row of values per key, with a value for each column
+// and operation pair. Keys are group values or file names; without a key
+// name there is a single row for all data.
+type results struct {
+	keyName string
+	cols    []column
+	ops     []string
+	keys    []string
+	rows    [][]float64
+}
+
+func (r *results) add(key string, row []float64) {
+	r.keys = append(r.keys, key)
+	r.rows = append(r.rows, row)
+}
+
+// values applies the operations to the accumulator of each column, in the
+// order of the results header.
+func values(accs []*accumulator, funcs []statsFunc) []float64 {
+	row := make([]float64, 0, len(accs)*len(funcs))
+	for _, a := range accs {
+		for _, f := range funcs {
+			row = append(row, f(a))
+		}
+	}
+
+	return row
+}
+
+// header names the values of a row, "<column> <operation>".
+func (r *results) header() []string {
+	var h []string
+	for _, c := range r.cols {
+		for _, op := range r.ops {
+			h = append(h, fmt.Sprintf("%s %s", c, op))
+		}
+	}
+
+	return h
+}
+
+// label names a value for plain output, leaving out the column or the
+// operation when there is only one.
+func (r *results) label(key string, j int) string {
+	var label []string
+	if r.keyName != "" {
+		label = append(label, key)
+	}
+
+	if len(r.cols) > 1 {
+		label = append(label, r.cols[j/len(r.ops)].String())
+	}
+
+	if len(r.ops) > 1 {
+		label = append(label, r.ops[j%len(r.ops)])
+	}
+
+	return strings.Join(label, " ")
+}
+
+// formatValue formats v with prec decimals, or with the fewest digits
+// that represent it exactly when prec is negative.
+func formatValue(v float64, prec int) string {
+	if prec < 0 {
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+
+	return strconv.FormatFloat(v, 'f', prec, 64)
+}
+
+// jsonValue rounds v to prec decimals. JSON has no NaN or infinity, so
+// those values become null.
+func jsonValue(v float64, prec int) interface{} {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+
+	if prec >= 0 {
+		v, _ = strconv.ParseFloat(formatValue(v, prec), 64)
+	}
+
+	return v
+}
+
+// printResults prints r as plain labelled lines, CSV, JSON or an aligned
+// table.
+func printResults(out io.Writer, format string, prec int, r *results) error {
+	header := r.header()
+	if r.keyName != "" {
+		header = append([]string{r.keyName}, header...)
+	}
+
+	record := func(i int) []string {
+		var rec []string
+		if r.keyName != "" {
+			rec = append(rec, r.keys[i])
+		}
+
+		for _, v := range r.rows[i] {
+			rec = append(rec, formatValue(v, prec))
+		}
+
+		return rec
+	}
+
+	switch format {
+	case "csv":
+		w := csv.NewWriter(out)
+		if err := w.Write(header); err != nil {
+			return err
+		}
+
+		for i := range r.rows {
+			if err := w.Write(record(i)); err != nil {
+				return err
+			}
+		}
+
+		w.Flush()
+		return w.Error()
+	case "json":
+		res := make([]map[string]interface{}, 0, len(r.rows))
+		for i, row := range r.rows {
+			obj := make(map[string]interface{})
+			if r.keyName != "" {
+				obj[r.keyName] = r.keys[i]
+			}
+
+			for j, h := range r.header() {
+				obj[h] = jsonValue(row[j], prec)
+			}
+			res = append(res, obj)
+		}
+
+		return json.NewEncoder(out).Encode(res)
+	case "table":
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, strings.Join(header, "\t"))
+
+		for i := range r.rows {
+			fmt.Fprintln(w, strings.Join(record(i), "\t"))
+		}
+
+		return w.Flush()
+	}
+
+	for i, row := range r.rows {
+		for j, v := range row {
+			label := r.label(r.keys[i], j)
+			if label == "" {
+				if _, err := fmt.Fprintln(out, formatValue(v, prec)); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if _, err := fmt.Fprintf(out, "%s: %s\n", label, formatValue(v, prec)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRunFormat(t *testing.T) {
+	files := []string{"./testdata/example.csv", "./testdata/example2.csv"}
+
+	tests := []struct {
+		name   string
+		cfg    config
+		exp    string
+		expErr error
+	}{
+		{name: "Plain", cfg: config{op: "avg", col: "3"}, exp: "233.84\n"},
+		{name: "PlainPrecision", cfg: config{op: "avg,max", col: "3", precision: "1"}, exp: "avg: 233.8\nmax: 238.0\n"},
+		{name: "CSV", cfg: config{op: "avg,max", col: "3", format: "csv"},
+			exp: "col 3 avg,col 3 max\n233.84,238\n"},
+		{name: "JSON", cfg: config{op: "avg,min", col: "3", format: "json", precision: "0"},
+			exp: `[{"col 3 avg":234,"col 3 min":218}]` + "\n"},
+		{name: "JSONNoValues", cfg: config{op: "min", col: "3", format: "json", where: []string{"3>1000"}},
+			exp: `[{"col 3 min":null}]` + "\n"},
+		{name: "Table", cfg: config{op: "avg,max", col: "3,Bytes", format: "table"},
+			exp: "col 3 avg  col 3 max  Bytes avg  Bytes max\n" +
+				"233.84     238        3666.96    3822\n"},
+		{name: "PerFilePlain", cfg: config{op: "avg", col: "3", perFile: true},
+			exp: "./testdata/example.csv: 227.6\n./testdata/example2.csv: 235.4\ntotal: 233.84\n"},
+		{name: "PerFileCSV", cfg: config{op: "count", col: "3", perFile: true, format: "csv"},
+			exp: "file,col 3 count\n./testdata/example.csv,5\n./testdata/example2.csv,20\ntotal,25\n"},
+		{name: "GroupTable", cfg: config{op: "count", col: "3", group: "IP Address", format: "table", where: []string{"IP Address^=192.168.0.1"}},
+			exp: "IP Address     col 3 count\n" +
+				"192.168.0.100  2\n" +
+				"192.168.0.199  20\n"},
+		{name: "FailPrecision", cfg: config{op: "avg", col: "3", precision: "-1"}, expErr: ErrInvalidFormat},
+		{name: "FailFormat", cfg: config{op: "avg", col: "3", format: "chart"}, expErr: ErrInvalidFormat},
+		{name: "FailGroupPerFile", cfg: config{op: "avg", col: "3", group: "1", perFile: true}, expErr: ErrInvalidFormat},
+		{name: "FailHistPerFile", cfg: config{op: "sum", col: "3", hist: true, buckets: 2, perFile: true}, expErr: ErrInvalidFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var res bytes.Buffer
+
+			err := run(context.Background(), files, tt.cfg, &res)
+			if tt.expErr != nil {
+				if !errors.Is(err, tt.expErr) {
+					t.Errorf("Expected error %q, got %q instead", tt.expErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %q", err)
+			}
+
+			if res.String() != tt.exp {
+				t.Errorf("Expected %q, got %q instead", tt.exp, res.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// sketchAccuracy is the relative error guaranteed for quantiles.
+const sketchAccuracy = 0.01
+
+// sketch is a mergeable quantile sketch with relative accuracy (DDSketch).
+// Values are counted in logarithmic buckets, so memory depends on the
+// range of the values and not on how many there are.
+type sketch struct {
+	lnGamma  float64
+	pos, neg map[int]uint64
+	zeros    uint64
+	count    uint64
+	min, max float64
+}
+
+func newSketch() *sketch {
+	gamma := (1 + sketchAccuracy) / (1 - sketchAccuracy)
+
+	return &sketch{
+		lnGamma: math.Log(gamma),
+		pos:     make(map[int]uint64),
+		neg:     make(map[int]uint64),
+		min:     math.Inf(1),
+		max:     math.Inf(-1),
+	}
+}
+
+func (s *sketch) index(v float64) int {
+	return int(math.Ceil(math.Log(v) / s.lnGamma))
+}
+
+func (s *sketch) value(i int) float64 {
+	gamma := math.Exp(s.lnGamma)
+	return 2 * math.Pow(gamma, float64(i)) / (gamma + 1)
+}
+
+func (s *sketch) add(v float64) {
+	switch {
+	case v > 0:
+		s.pos[s.index(v)]++
+	case v < 0:
+		s.neg[s.index(-v)]++
+	default:
+		s.zeros++
+	}
+
+	s.count++
+	s.min = math.Min(s.min, v)
+	s.max = math.Max(s.max, v)
+}
+
+func (s *sketch) merge(o *sketch) {
+	for i, n := range o.pos {
+		s.pos[i] += n
+	}
+
+	for i, n := range o.neg {
+		s.neg[i] += n
+	}
+
+	s.zeros += o.zeros
+	s.count += o.count
+	s.min = math.Min(s.min, o.min)
+	s.max = math.Max(s.max, o.max)
+}
+
+// quantile returns the approximate q-quantile, 0 <= q <= 1.
+func (s *sketch) quantile(q float64) float64 {
+	if s.count == 0 {
+		return math.NaN()
+	}
+
+	rank := uint64(math.Round(q * float64(s.count-1)))
+
+	var v float64
+	found := false
+	seen := uint64(0)
+
+	negIdx := sortedKeys(s.neg)
+	for i := len(negIdx) - 1; i >= 0 && !found; i-- {
+		seen += s.neg[negIdx[i]]
+		if seen > rank {
+			v, found = -s.value(negIdx[i]), true
+		}
+	}
+
+	if !found {
+		seen += s.zeros
+		if seen > rank {
+			v, found = 0, true
+		}
+	}
+
+	for _, i := range sortedKeys(s.pos) {
+		if found {
+			break
+		}
+
+		seen += s.pos[i]
+		if seen > rank {
+			v, found = s.value(i), true
+		}
+	}
+
+	return math.Max(s.min, math.Min(s.max, v))
+}
+
+// each calls fn with the value and count of every bucket, in increasing
+// order of value.
+func (s *sketch) each(fn func(v float64, n uint64)) {
+	negIdx := sortedKeys(s.neg)
+	for i := len(negIdx) - 1; i >= 0; i-- {
+		fn(-s.value(negIdx[i]), s.neg[negIdx[i]])
+	}
+
+	if s.zeros > 0 {
+		fn(0, s.zeros)
+	}
+
+	for _, i := range sortedKeys(s.pos) {
+		fn(s.value(i), s.pos[i])
+	}
+}
+
+func sortedKeys(m map[int]uint64) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Ints(keys)
+	return keys
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// windowLayout formats window start times. All keys have the same length
+// and are in UTC, so sorting them as strings orders them in time.
+const windowLayout = "2006-01-02T15:04:05Z"
+
+// window buckets rows into fixed time windows by the value of a timestamp
+// column. Windows are aligned to whole multiples of their size in UTC.
+type window struct {
+	size   time.Duration
+	layout string
+}
+
+// parseWindow parses a window size such as "1m" or "1h" and a timestamp
+// layout: "unix" or "unixms" for epoch seconds or milliseconds,
+// "rfc3339", or a Go time layout such as "2006-01-02 15:04:05".
+func parseWindow(size, layout string) (*window, error) {
+	d, err := time.ParseDuration(size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: window: %s", ErrInvalidFormat, err)
+	}
+
+	if d < time.Second || d%time.Second != 0 {
+		return nil, fmt.Errorf("%w: window must be whole seconds, got %s", ErrInvalidFormat, d)
+	}
+
+	switch layout {
+	case "", "unix":
+		layout = "unix"
+	case "rfc3339":
+		layout = time.RFC3339
+	}
+
+	return &window{size: d, layout: layout}, nil
+}
+
+func (w *window) parse(v string) (time.Time, error) {
+	switch w.layout {
+	case "unix", "unixms":
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+			return time.Time{}, fmt.Errorf("%w: %q is not a %s timestamp", ErrInvalidTime, v, w.layout)
+		}
+
+		if w.layout == "unixms" {
+			return time.UnixMilli(int64(n)), nil
+		}
+
+		sec, frac := math.Modf(n)
+		return time.Unix(int64(sec), int64(frac*1e9)), nil
+	}
+
+	t, err := time.Parse(w.layout, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %s", ErrInvalidTime, err)
+	}
+
+	return t, nil
+}
+
+// key returns the start of the window holding the timestamp v.
+func (w *window) key(v string) (string, error) {
+	t, err := w.parse(v)
+	if err != nil {
+		return "", err
+	}
+
+	return t.Truncate(w.size).UTC().Format(windowLayout), nil
+}
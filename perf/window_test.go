@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestWindowKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   string
+		layout string
+		v      string
+		exp    string
+		expErr error
+	}{
+		{name: "UnixMinute", size: "1m", layout: "unix", v: "1520698621", exp: "2018-03-10T16:17:00Z"},
+		{name: "UnixFraction", size: "1s", layout: "unix", v: "1520698621.75", exp: "2018-03-10T16:17:01Z"},
+		{name: "UnixMsHour", size: "1h", layout: "unixms", v: "1520698621500", exp: "2018-03-10T16:00:00Z"},
+		{name: "RFC3339Zone", size: "1h", layout: "rfc3339", v: "2018-03-10T17:30:00+01:00", exp: "2018-03-10T16:00:00Z"},
+		{name: "Layout", size: "15m", layout: "2006-01-02 15:04:05", v: "2018-03-10 16:44:59", exp: "2018-03-10T16:30:00Z"},
+		{name: "FailNotUnix", size: "1m", layout: "unix", v: "yesterday", expErr: ErrInvalidTime},
+		{name: "FailLayout", size: "1m", layout: "2006-01-02", v: "10/03/2018", expErr: ErrInvalidTime},
+		{name: "FailSize", size: "10ms", layout: "unix", expErr: ErrInvalidFormat},
+		{name: "FailDuration", size: "hourly", layout: "unix", expErr: ErrInvalidFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := parseWindow(tt.size, tt.layout)
+
+			var key string
+			if err == nil {
+				key, err = w.key(tt.v)
+			}
+
+			if tt.expErr != nil {
+				if !errors.Is(err, tt.expErr) {
+					t.Errorf("Expected error %q, got %q instead", tt.expErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %q", err)
+			}
+
+			if key != tt.exp {
+				t.Errorf("Expected %q, got %q instead", tt.exp, key)
+			}
+		})
+	}
+}
+
+func TestRunWindow(t *testing.T) {
+	// Rows out of order.
+	unsorted := `Time,Latency
+2018-03-10 16:29:10,300
+2018-03-10 16:01:00,100
+2018-03-10 16:31:00,500
+2018-03-10 16:02:30,200
+`
+
+	tests := []struct {
+		name   string
+		files  []string
+		stdin  string
+		cfg    config
+		exp    string
+		expErr error
+	}{
+		{name: "FiveMinutes", files: []string{"./testdata/example2.csv", "./testdata/example.csv"},
+			cfg: config{op: "count,max", col: "Response Time", timeCol: "Timestamp", window: "5m"},
+			exp: "window,Response Time count,Response Time max\n" +
+				"2018-03-10T16:15:00Z,4,236\n" +
+				"2018-03-10T16:20:00Z,2,226\n" +
+				"2018-03-10T16:25:00Z,19,238\n"},
+		{name: "UnsortedLayout", files: []string{"-"}, stdin: unsorted,
+			cfg: config{op: "avg", col: "Latency", timeCol: "Time", timeLayout: "2006-01-02 15:04:05", window: "30m", format: "json"},
+			exp: `[{"Latency avg":200,"window":"2018-03-10T16:00:00Z"},{"Latency avg":500,"window":"2018-03-10T16:30:00Z"}]` + "\n"},
+		{name: "FailTimeColumn", files: []string{"./testdata/example.csv"},
+			cfg: config{op: "avg", col: "3", window: "1m"}, expErr: ErrInvalidColumn},
+		{name: "FailTimestamp", files: []string{"./testdata/example.csv"},
+			cfg: config{op: "avg", col: "3", timeCol: "IP Address", window: "1m"}, expErr: ErrInvalidTime},
+		{name: "FailGroup", files: []string{"./testdata/example.csv"},
+			cfg: config{op: "avg", col: "3", timeCol: "2", group: "1", window: "1m"}, expErr: ErrInvalidFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var res bytes.Buffer
+
+			tt.cfg.stdin = strings.NewReader(tt.stdin)
+
+			err := run(context.Background(), tt.files, tt.cfg, &res)
+			if tt.expErr != nil {
+				if !errors.Is(err, tt.expErr) {
+					t.Errorf("Expected error %q, got %q instead", tt.expErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %q", err)
+			}
+
+			if res.String() != tt.exp {
+				t.Errorf("Expected %q, got %q instead", tt.exp, res.String())
+			}
+		})
+	}
+}